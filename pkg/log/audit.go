@@ -0,0 +1,182 @@
+package log
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditOutcome is the result of an audited action
+type AuditOutcome string
+
+const (
+	AuditSuccess AuditOutcome = "success"
+	AuditFailure AuditOutcome = "failure"
+	AuditDenied  AuditOutcome = "denied"
+)
+
+// ErrAuditChainBroken is returned by VerifyAuditLog when an entry was edited, removed or reordered
+var ErrAuditChainBroken = errors.New("audit chain broken")
+
+// AuditEvent records who performed which action against which resource
+type AuditEvent struct {
+	Actor    string
+	Action   string
+	Resource string
+	Outcome  AuditOutcome
+	Time     time.Time
+}
+
+// AuditChainHead identifies the last entry of an audit chain. Persist it
+// somewhere other than the audit sink to detect truncation of the tail.
+type AuditChainHead struct {
+	Seq  uint64
+	Hash string
+}
+
+// AuditLogger writes AuditEvents to a dedicated sink, separate from operational logs
+type AuditLogger interface {
+	Audit(AuditEvent) error
+	Head() AuditChainHead
+}
+
+// auditRecord is the line written to the sink. Field order is part of the hash
+// input, so it must not change.
+type auditRecord struct {
+	Seq      uint64       `json:"seq"`
+	Time     time.Time    `json:"ts"`
+	Actor    string       `json:"actor"`
+	Action   string       `json:"action"`
+	Resource string       `json:"resource"`
+	Outcome  AuditOutcome `json:"outcome"`
+	Prev     string       `json:"prev"`
+	Hash     string       `json:"hash,omitempty"`
+}
+
+type auditLogger struct {
+	mu     sync.Mutex
+	writer io.Writer
+	key    []byte
+	head   AuditChainHead
+}
+
+// NewAuditLogger starts a new hash chain written to writer as one JSON object per
+// line. When key is not empty entries are chained with HMAC-SHA256 so that the
+// chain cannot be recomputed without it, otherwise plain SHA-256 is used.
+func NewAuditLogger(writer io.Writer, key []byte) AuditLogger {
+	return NewAuditLoggerFrom(writer, key, AuditChainHead{})
+}
+
+// NewAuditLoggerFrom continues an existing chain, e.g. with the head returned by
+// VerifyAuditLog after a restart
+func NewAuditLoggerFrom(writer io.Writer, key []byte, head AuditChainHead) AuditLogger {
+	return &auditLogger{writer: writer, key: key, head: head}
+}
+
+// Audit appends event to the chain
+func (a *auditLogger) Audit(event AuditEvent) error {
+	if len(event.Actor) == 0 || len(event.Action) == 0 || len(event.Resource) == 0 || len(event.Outcome) == 0 {
+		return errors.New("audit event requires actor, action, resource and outcome")
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	record := auditRecord{
+		Seq:      a.head.Seq + 1,
+		Time:     event.Time.UTC(),
+		Actor:    event.Actor,
+		Action:   event.Action,
+		Resource: event.Resource,
+		Outcome:  event.Outcome,
+		Prev:     a.head.Hash,
+	}
+	sum, err := auditHash(a.key, record)
+	if err != nil {
+		return err
+	}
+	record.Hash = sum
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("problem encoding audit event: %w", err)
+	}
+	if _, err := a.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("problem writing audit event: %w", err)
+	}
+
+	a.head = AuditChainHead{Seq: record.Seq, Hash: record.Hash}
+	return nil
+}
+
+// Head returns the last entry written
+func (a *auditLogger) Head() AuditChainHead {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.head
+}
+
+// VerifyAuditLog reads a chain written by an AuditLogger and checks that every
+// entry links to its predecessor and that its hash matches its content. It
+// returns the head of the chain, which callers should compare against a head
+// stored elsewhere to detect removal of trailing entries.
+func VerifyAuditLog(reader io.Reader, key []byte) (AuditChainHead, error) {
+	var head AuditChainHead
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return head, fmt.Errorf("%w: entry after seq %d is not valid: %v", ErrAuditChainBroken, head.Seq, err)
+		}
+		if record.Seq != head.Seq+1 {
+			return head, fmt.Errorf("%w: expected seq %d, found %d", ErrAuditChainBroken, head.Seq+1, record.Seq)
+		}
+		if record.Prev != head.Hash {
+			return head, fmt.Errorf("%w: seq %d does not link to its predecessor", ErrAuditChainBroken, record.Seq)
+		}
+
+		claimed := record.Hash
+		record.Hash = ""
+		sum, err := auditHash(key, record)
+		if err != nil {
+			return head, err
+		}
+		if !hmac.Equal([]byte(sum), []byte(claimed)) {
+			return head, fmt.Errorf("%w: seq %d has been modified", ErrAuditChainBroken, record.Seq)
+		}
+
+		head = AuditChainHead{Seq: record.Seq, Hash: claimed}
+	}
+	if err := scanner.Err(); err != nil {
+		return head, fmt.Errorf("problem reading audit log: %w", err)
+	}
+
+	return head, nil
+}
+
+func auditHash(key []byte, record auditRecord) (string, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("problem encoding audit event: %w", err)
+	}
+
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
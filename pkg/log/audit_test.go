@@ -0,0 +1,56 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeAuditLog(t *testing.T, key []byte) (*bytes.Buffer, AuditChainHead) {
+	buf := &bytes.Buffer{}
+	logger := NewAuditLogger(buf, key)
+	for _, action := range []string{"secret.read", "secret.store", "secret.delete"} {
+		require.NoError(t, logger.Audit(AuditEvent{
+			Actor:    "svc-billing",
+			Action:   action,
+			Resource: "db-password",
+			Outcome:  AuditSuccess,
+			Time:     time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC),
+		}))
+	}
+	return buf, logger.Head()
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	buf, head := writeAuditLog(t, []byte("key"))
+
+	verified, err := VerifyAuditLog(buf, []byte("key"))
+	require.NoError(t, err)
+	assert.Equal(t, head, verified)
+	assert.Equal(t, uint64(3), verified.Seq)
+}
+
+func TestVerifyAuditLogDetectsTampering(t *testing.T) {
+	buf, _ := writeAuditLog(t, nil)
+	lines := strings.SplitAfter(buf.String(), "\n")
+
+	edited := strings.Replace(buf.String(), "secret.store", "secret.reads", 1)
+	_, err := VerifyAuditLog(strings.NewReader(edited), nil)
+	assert.ErrorIs(t, err, ErrAuditChainBroken)
+
+	deleted := lines[0] + lines[2]
+	_, err = VerifyAuditLog(strings.NewReader(deleted), nil)
+	assert.ErrorIs(t, err, ErrAuditChainBroken)
+
+	_, err = VerifyAuditLog(strings.NewReader(buf.String()), []byte("wrong key"))
+	assert.ErrorIs(t, err, ErrAuditChainBroken)
+}
+
+func TestAuditRequiresSchema(t *testing.T) {
+	err := NewAuditLogger(&bytes.Buffer{}, nil).Audit(AuditEvent{Actor: "someone"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupLogger is a FieldLogger that collapses identical entries logged within
+// a window into the first entry plus a single summary carrying a repeated count
+type DedupLogger interface {
+	FieldLogger
+	// Close flushes every pending summary. Entries logged after Close are
+	// passed through without deduplication.
+	Close() error
+}
+
+type dedupLogger struct {
+	logger Logger
+	fields Fields
+	state  *dedupState
+}
+
+// dedupState is shared by a dedupLogger and every child created with WithFields
+type dedupState struct {
+	mu      sync.Mutex
+	window  time.Duration
+	closed  bool
+	pending map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	logger Logger
+	fields Fields
+	level  level
+	msg    string
+	count  int
+	timer  *time.Timer
+}
+
+// NewDedupLogger wraps logger so that identical (level, message, fields)
+// entries within window are only written once. When the window closes the
+// number of suppressed entries is written under RepeatedKey.
+func NewDedupLogger(logger Logger, window time.Duration) DedupLogger {
+	return dedupLogger{
+		logger: logger,
+		state: &dedupState{
+			window:  window,
+			pending: make(map[string]*dedupEntry),
+		},
+	}
+}
+
+// WithFields returns a child logger sharing the same deduplication window
+func (d dedupLogger) WithFields(fields Fields) FieldLogger {
+	merged := make(Fields, len(d.fields)+len(fields))
+	for k, v := range d.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	// logger is told about this frame so that a warning it writes about the
+	// fields points at our caller, and the child is set back afterwards
+	logger := d.logger
+	if fl, ok := skipCallers(logger, 1).(FieldLogger); ok {
+		logger = skipCallers(fl.WithFields(fields), -1)
+	}
+
+	return dedupLogger{logger: logger, fields: merged, state: d.state}
+}
+
+// Close flushes all pending summaries
+func (d dedupLogger) Close() error {
+	d.state.mu.Lock()
+	d.state.closed = true
+	pending := d.state.pending
+	d.state.pending = make(map[string]*dedupEntry)
+	d.state.mu.Unlock()
+
+	for _, entry := range pending {
+		entry.timer.Stop()
+		entry.flush()
+	}
+	return nil
+}
+
+func (d dedupLogger) log(lvl level, msg string) {
+	// entries pass through a method, log and logAt before reaching the logger
+	logger := skipCallers(d.logger, 3)
+	if lvl == fatalLevel {
+		// the process is about to exit, so whatever was suppressed must be written first
+		_ = d.Close()
+		logAt(logger, lvl, msg)
+		return
+	}
+
+	key := dedupKey(lvl, msg, d.fields)
+
+	d.state.mu.Lock()
+	if d.state.closed || d.state.window <= 0 {
+		d.state.mu.Unlock()
+		logAt(logger, lvl, msg)
+		return
+	}
+	if entry, ok := d.state.pending[key]; ok {
+		entry.count++
+		d.state.mu.Unlock()
+		return
+	}
+	entry := &dedupEntry{logger: d.logger, fields: d.fields, level: lvl, msg: msg}
+	entry.timer = time.AfterFunc(d.state.window, func() { d.state.expire(key, entry) })
+	d.state.pending[key] = entry
+	d.state.mu.Unlock()
+
+	logAt(logger, lvl, msg)
+}
+
+func (s *dedupState) expire(key string, entry *dedupEntry) {
+	s.mu.Lock()
+	if s.pending[key] != entry {
+		// already flushed by Close
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, key)
+	s.mu.Unlock()
+
+	entry.flush()
+}
+
+// flush writes the summary for an entry if any duplicates were suppressed
+func (e *dedupEntry) flush() {
+	if e.count == 0 {
+		return
+	}
+
+	// summaries are written from a timer or Close, not by the code that logged
+	logger := withoutCaller(e.logger)
+	if fl, ok := logger.(FieldLogger); ok {
+		logAt(fl.WithFields(Fields{RepeatedKey: e.count}), e.level, e.msg)
+		return
+	}
+	logAt(logger, e.level, fmt.Sprintf("%s (%s=%d)", e.msg, RepeatedKey, e.count))
+}
+
+// dedupKey renders an entry identity with fields sorted by key so that map
+// ordering does not affect equality
+func dedupKey(lvl level, msg string, fields Fields) string {
+	var b strings.Builder
+	b.WriteString(lvl.String())
+	b.WriteByte(0)
+	b.WriteString(msg)
+	for _, k := range sortedKeys(fields) {
+		fmt.Fprintf(&b, "\x00%s=%v", k, fields[k])
+	}
+	return b.String()
+}
+
+func (d dedupLogger) Debug(args ...any) {
+	d.log(debugLevel, fmt.Sprint(args...))
+}
+
+func (d dedupLogger) Debugln(args ...any) {
+	d.log(debugLevel, sprintlnn(args...))
+}
+
+func (d dedupLogger) Debugf(format string, args ...interface{}) {
+	d.log(debugLevel, fmt.Sprintf(format, args...))
+}
+
+func (d dedupLogger) Info(args ...any) {
+	d.log(infoLevel, fmt.Sprint(args...))
+}
+
+func (d dedupLogger) Infoln(args ...any) {
+	d.log(infoLevel, sprintlnn(args...))
+}
+
+func (d dedupLogger) Infof(format string, args ...interface{}) {
+	d.log(infoLevel, fmt.Sprintf(format, args...))
+}
+
+func (d dedupLogger) Warn(args ...any) {
+	d.log(warnLevel, fmt.Sprint(args...))
+}
+
+func (d dedupLogger) Warnln(args ...any) {
+	d.log(warnLevel, sprintlnn(args...))
+}
+
+func (d dedupLogger) Warnf(format string, args ...interface{}) {
+	d.log(warnLevel, fmt.Sprintf(format, args...))
+}
+
+func (d dedupLogger) Error(args ...any) {
+	d.log(errorLevel, fmt.Sprint(args...))
+}
+
+func (d dedupLogger) Errorln(args ...any) {
+	d.log(errorLevel, sprintlnn(args...))
+}
+
+func (d dedupLogger) Errorf(format string, args ...interface{}) {
+	d.log(errorLevel, fmt.Sprintf(format, args...))
+}
+
+func (d dedupLogger) Fatal(args ...any) {
+	d.log(fatalLevel, fmt.Sprint(args...))
+}
+
+func (d dedupLogger) Fatalln(args ...any) {
+	d.log(fatalLevel, sprintlnn(args...))
+}
+
+func (d dedupLogger) Fatalf(format string, args ...interface{}) {
+	d.log(fatalLevel, fmt.Sprintf(format, args...))
+}
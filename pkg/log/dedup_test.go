@@ -0,0 +1,135 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer guards a bytes.Buffer since summaries are written from timer goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) entries(t *testing.T) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(s.buf.String()), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		entry := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		out = append(out, entry)
+	}
+	return out
+}
+
+func TestDedupLoggerCollapsesOnClose(t *testing.T) {
+	buf := &syncBuffer{}
+	logger := NewDedupLogger(NewZeroLogger(buf), time.Hour)
+
+	for i := 0; i < 5; i++ {
+		logger.Warnf("connection refused to %s", "db")
+	}
+	logger.Info("unrelated")
+	require.NoError(t, logger.Close())
+
+	entries := buf.entries(t)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "connection refused to db", entries[0][MessageKey])
+	assert.Nil(t, entries[0][RepeatedKey])
+	assert.Equal(t, "unrelated", entries[1][MessageKey])
+	assert.Equal(t, "connection refused to db", entries[2][MessageKey])
+	assert.Equal(t, float64(4), entries[2][RepeatedKey])
+}
+
+func TestDedupLoggerFlushesWhenWindowCloses(t *testing.T) {
+	buf := &syncBuffer{}
+	logger := NewDedupLogger(NewZeroLogger(buf), 10*time.Millisecond)
+	defer logger.Close()
+
+	logger.Error("boom")
+	logger.Error("boom")
+
+	assert.Eventually(t, func() bool { return len(buf.entries(t)) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, float64(1), buf.entries(t)[1][RepeatedKey])
+
+	logger.Error("boom")
+	assert.Len(t, buf.entries(t), 3, "a new window should start after the previous one closed")
+}
+
+func TestDedupLoggerDistinguishesLevelAndFields(t *testing.T) {
+	buf := &syncBuffer{}
+	logger := NewDedupLogger(NewZeroLogger(buf), time.Hour)
+
+	logger.Info("msg")
+	logger.Warn("msg")
+	logger.WithFields(Fields{"user": "a"}).Info("msg")
+	logger.WithFields(Fields{"user": "b"}).Info("msg")
+	logger.WithFields(Fields{"user": "b"}).Info("msg")
+	require.NoError(t, logger.Close())
+
+	entries := buf.entries(t)
+	require.Len(t, entries, 5)
+	assert.Equal(t, "b", entries[4]["user"])
+	assert.Equal(t, float64(1), entries[4][RepeatedKey])
+}
+
+func TestDedupLoggerPassesThroughAfterClose(t *testing.T) {
+	buf := &syncBuffer{}
+	logger := NewDedupLogger(NewZeroLogger(buf), time.Hour)
+	require.NoError(t, logger.Close())
+
+	logger.Info("msg")
+	logger.Info("msg")
+
+	assert.Len(t, buf.entries(t), 2)
+}
+
+// nextLine returns the SourceKey value expected for a call on the line after the caller
+func nextLine(t *testing.T) string {
+	_, file, line, ok := runtime.Caller(1)
+	require.True(t, ok)
+	return afterLastSlash(file) + ":" + strconv.Itoa(line+1)
+}
+
+func TestDedupLoggerReportsCallerSource(t *testing.T) {
+	buf := &syncBuffer{}
+	plain := NewZeroLogger(buf)
+	logger := NewDedupLogger(NewSchemaLogger(plain, DefaultFieldSchema(), SchemaStrict), time.Hour)
+
+	direct := nextLine(t)
+	plain.Info("direct")
+	deduped := nextLine(t)
+	logger.Warn("deduped")
+	logger.Warn("deduped")
+	fields := nextLine(t)
+	logger.WithFields(Fields{CallKey: "test"}).Errorf("with %s", "fields")
+	require.NoError(t, logger.Close())
+
+	entries := buf.entries(t)
+	require.Len(t, entries, 4)
+	assert.Equal(t, direct, entries[0][SourceKey])
+	assert.Equal(t, deduped, entries[1][SourceKey])
+	assert.Equal(t, fields, entries[2][SourceKey])
+	assert.Equal(t, float64(1), entries[3][RepeatedKey])
+	assert.NotContains(t, entries[3], SourceKey, "summaries are not written by a caller")
+}
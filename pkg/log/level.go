@@ -0,0 +1,71 @@
+package log
+
+import "fmt"
+
+// level identifies the severity of an entry routed through a Logger decorator
+type level int
+
+const (
+	debugLevel level = iota
+	infoLevel
+	warnLevel
+	errorLevel
+	fatalLevel
+)
+
+func (l level) String() string {
+	switch l {
+	case debugLevel:
+		return "debug"
+	case infoLevel:
+		return "info"
+	case warnLevel:
+		return "warn"
+	case errorLevel:
+		return "error"
+	case fatalLevel:
+		return "fatal"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// callerLogger is implemented by loggers that write the call site under
+// SourceKey, so that decorators can point it past their own frames
+type callerLogger interface {
+	skipCallers(frames int) Logger
+	withoutCaller() Logger
+}
+
+// skipCallers returns logger reporting the call site frames further up the stack
+func skipCallers(logger Logger, frames int) Logger {
+	if cl, ok := logger.(callerLogger); ok {
+		return cl.skipCallers(frames)
+	}
+	return logger
+}
+
+// withoutCaller returns logger leaving out the call site, for entries that are
+// not written on behalf of a caller
+func withoutCaller(logger Logger) Logger {
+	if cl, ok := logger.(callerLogger); ok {
+		return cl.withoutCaller()
+	}
+	return logger
+}
+
+// logAt writes an already formatted message to logger at the given level
+func logAt(logger Logger, lvl level, msg string) {
+	switch lvl {
+	case debugLevel:
+		logger.Debug(msg)
+	case infoLevel:
+		logger.Info(msg)
+	case warnLevel:
+		logger.Warn(msg)
+	case errorLevel:
+		logger.Error(msg)
+	case fatalLevel:
+		logger.Fatal(msg)
+	}
+}
@@ -21,3 +21,12 @@ type Logger interface {
 	Fatalln(...interface{})
 	Fatalf(string, ...interface{})
 }
+
+// Fields are structured key/value pairs attached to every entry of a FieldLogger
+type Fields map[string]interface{}
+
+// FieldLogger is a Logger that can carry structured fields
+type FieldLogger interface {
+	Logger
+	WithFields(Fields) FieldLogger
+}
@@ -0,0 +1,57 @@
+// Package logtest provides helpers for asserting on logging behaviour in tests.
+package logtest
+
+import (
+	"testing"
+
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+type schemaCheckingLogger struct {
+	log.FieldLogger
+	t      testing.TB
+	schema *log.FieldSchema
+}
+
+// NewSchemaCheckingLogger returns a FieldLogger that fails t whenever a field is
+// added that is not registered in schema or does not match its registered type.
+// Entries are written to logger, which may be nil to discard them.
+func NewSchemaCheckingLogger(t testing.TB, logger log.FieldLogger, schema *log.FieldSchema) log.FieldLogger {
+	if logger == nil {
+		logger = NewNopLogger()
+	}
+	return schemaCheckingLogger{FieldLogger: logger, t: t, schema: schema}
+}
+
+// WithFields fails the test for every unregistered or mistyped field
+func (s schemaCheckingLogger) WithFields(fields log.Fields) log.FieldLogger {
+	s.t.Helper()
+	if _, err := s.schema.Validate(fields); err != nil {
+		s.t.Errorf("logged fields do not match schema: %v", err)
+	}
+	return schemaCheckingLogger{FieldLogger: s.FieldLogger.WithFields(fields), t: s.t, schema: s.schema}
+}
+
+type nopLogger struct{}
+
+// NewNopLogger returns a FieldLogger that discards every entry
+func NewNopLogger() log.FieldLogger {
+	return nopLogger{}
+}
+
+func (n nopLogger) WithFields(log.Fields) log.FieldLogger { return n }
+func (nopLogger) Debug(...interface{})                    {}
+func (nopLogger) Debugln(...interface{})                  {}
+func (nopLogger) Debugf(string, ...interface{})           {}
+func (nopLogger) Info(...interface{})                     {}
+func (nopLogger) Infoln(...interface{})                   {}
+func (nopLogger) Infof(string, ...interface{})            {}
+func (nopLogger) Warn(...interface{})                     {}
+func (nopLogger) Warnln(...interface{})                   {}
+func (nopLogger) Warnf(string, ...interface{})            {}
+func (nopLogger) Error(...interface{})                    {}
+func (nopLogger) Errorln(...interface{})                  {}
+func (nopLogger) Errorf(string, ...interface{})           {}
+func (nopLogger) Fatal(...interface{})                    {}
+func (nopLogger) Fatalln(...interface{})                  {}
+func (nopLogger) Fatalf(string, ...interface{})           {}
@@ -0,0 +1,55 @@
+package logtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+// fakeTB records the failures reported through Errorf
+type fakeTB struct {
+	testing.TB
+	failures []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.failures = append(f.failures, fmt.Sprintf(format, args...))
+}
+
+func TestSchemaCheckingLogger(t *testing.T) {
+	schema := log.DefaultFieldSchema().Register("user_id", log.StringField)
+
+	tests := []struct {
+		name   string
+		fields log.Fields
+		failed bool
+	}{
+		{name: "registered", fields: log.Fields{"user_id": "abc", log.DurationKey: time.Second}},
+		{name: "unregistered", fields: log.Fields{"colour": "red"}, failed: true},
+		{name: "mistyped", fields: log.Fields{"user_id": 12}, failed: true},
+		{name: "reserved", fields: log.Fields{log.MessageKey: "spoofed"}, failed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := &fakeTB{}
+			recorder := NewRecorder()
+			NewSchemaCheckingLogger(tb, recorder, schema).WithFields(tt.fields).Info("checked")
+
+			if tt.failed {
+				assert.Len(t, tb.failures, 1)
+			} else {
+				assert.Empty(t, tb.failures)
+			}
+			entries := recorder.Entries()
+			if assert.Len(t, entries, 1, "entries are written either way") {
+				assert.Equal(t, tt.fields, entries[0].Fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package logtest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+// Entry is a single entry captured by a Recorder
+type Entry struct {
+	Level   string
+	Message string
+	Fields  log.Fields
+}
+
+// Recorder is a FieldLogger that keeps every entry in memory so tests can assert on them
+type Recorder struct {
+	mu      *sync.Mutex
+	entries *[]Entry
+	fields  log.Fields
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{mu: &sync.Mutex{}, entries: &[]Entry{}}
+}
+
+// Entries returns a copy of every entry recorded so far, including those of child loggers
+func (r *Recorder) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry(nil), *r.entries...)
+}
+
+// WithFields returns a child Recorder that shares entries with r
+func (r *Recorder) WithFields(fields log.Fields) log.FieldLogger {
+	merged := make(log.Fields, len(r.fields)+len(fields))
+	for k, v := range r.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Recorder{mu: r.mu, entries: r.entries, fields: merged}
+}
+
+func (r *Recorder) record(level, msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*r.entries = append(*r.entries, Entry{Level: level, Message: msg, Fields: r.fields})
+}
+
+func (r *Recorder) Debug(args ...interface{})   { r.record("debug", fmt.Sprint(args...)) }
+func (r *Recorder) Debugln(args ...interface{}) { r.record("debug", sprintlnn(args...)) }
+func (r *Recorder) Debugf(format string, args ...interface{}) {
+	r.record("debug", fmt.Sprintf(format, args...))
+}
+func (r *Recorder) Info(args ...interface{})   { r.record("info", fmt.Sprint(args...)) }
+func (r *Recorder) Infoln(args ...interface{}) { r.record("info", sprintlnn(args...)) }
+func (r *Recorder) Infof(format string, args ...interface{}) {
+	r.record("info", fmt.Sprintf(format, args...))
+}
+func (r *Recorder) Warn(args ...interface{})   { r.record("warn", fmt.Sprint(args...)) }
+func (r *Recorder) Warnln(args ...interface{}) { r.record("warn", sprintlnn(args...)) }
+func (r *Recorder) Warnf(format string, args ...interface{}) {
+	r.record("warn", fmt.Sprintf(format, args...))
+}
+func (r *Recorder) Error(args ...interface{})   { r.record("error", fmt.Sprint(args...)) }
+func (r *Recorder) Errorln(args ...interface{}) { r.record("error", sprintlnn(args...)) }
+func (r *Recorder) Errorf(format string, args ...interface{}) {
+	r.record("error", fmt.Sprintf(format, args...))
+}
+
+// Fatal records the entry without exiting the process
+func (r *Recorder) Fatal(args ...interface{})   { r.record("fatal", fmt.Sprint(args...)) }
+func (r *Recorder) Fatalln(args ...interface{}) { r.record("fatal", sprintlnn(args...)) }
+func (r *Recorder) Fatalf(format string, args ...interface{}) {
+	r.record("fatal", fmt.Sprintf(format, args...))
+}
+
+func sprintlnn(args ...interface{}) string {
+	msg := fmt.Sprintln(args...)
+	return msg[:len(msg)-1]
+}
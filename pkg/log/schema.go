@@ -0,0 +1,302 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FieldType is the kind of value a registered field key is allowed to carry
+type FieldType int
+
+const (
+	AnyField FieldType = iota
+	StringField
+	IntField
+	FloatField
+	BoolField
+	DurationField
+	TimeField
+	ErrorField
+)
+
+func (f FieldType) String() string {
+	switch f {
+	case AnyField:
+		return "any"
+	case StringField:
+		return "string"
+	case IntField:
+		return "int"
+	case FloatField:
+		return "float"
+	case BoolField:
+		return "bool"
+	case DurationField:
+		return "duration"
+	case TimeField:
+		return "time"
+	case ErrorField:
+		return "error"
+	default:
+		return fmt.Sprintf("FieldType(%d)", int(f))
+	}
+}
+
+// SchemaMode controls how a schema enforcing logger treats keys that are not registered
+type SchemaMode int
+
+const (
+	// SchemaPermissive renames aliases and passes unknown keys through untouched
+	SchemaPermissive SchemaMode = iota
+	// SchemaStrict renames aliases and drops unknown or mistyped keys, logging a warning naming them
+	SchemaStrict
+)
+
+var (
+	ErrUnregisteredField = errors.New("unregistered log field")
+	ErrFieldType         = errors.New("log field has wrong type")
+	ErrReservedField     = errors.New("log field is written by the logger")
+)
+
+// reservedKeys are written by NewZeroLogger itself, so a field using one would
+// produce a duplicate key
+var reservedKeys = map[string]bool{
+	LevelKey:     true,
+	MessageKey:   true,
+	SourceKey:    true,
+	TimestampKey: true,
+}
+
+// FieldSchema is a registry of allowed field keys, their types, and aliases that
+// should be renamed to a canonical key
+type FieldSchema struct {
+	mu      sync.RWMutex
+	types   map[string]FieldType
+	aliases map[string]string
+}
+
+// NewFieldSchema returns an empty FieldSchema
+func NewFieldSchema() *FieldSchema {
+	return &FieldSchema{
+		types:   make(map[string]FieldType),
+		aliases: make(map[string]string),
+	}
+}
+
+// DefaultFieldSchema returns a FieldSchema with the field keys used by this
+// module registered
+func DefaultFieldSchema() *FieldSchema {
+	return NewFieldSchema().
+		Register(ArgCountKey, IntField).
+		Register(CallKey, StringField).
+		Register(DurationKey, DurationField).
+		Register(ErrorKey, ErrorField).
+		Register(RepeatedKey, IntField).
+		Register(RowsKey, IntField).
+		Register(StatementKey, StringField).
+		Register(TraceIDKey, StringField)
+}
+
+// Register allows key with values of the given type
+func (s *FieldSchema) Register(key string, typ FieldType) *FieldSchema {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.types[key] = typ
+	return s
+}
+
+// Alias renames alias to the registered key canonical, e.g. userId and uid to user_id
+func (s *FieldSchema) Alias(canonical string, aliases ...string) *FieldSchema {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, alias := range aliases {
+		s.aliases[alias] = canonical
+	}
+	return s
+}
+
+// Keys returns the registered keys in sorted order
+func (s *FieldSchema) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.types))
+	for k := range s.types {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate renames aliased keys and checks every field against the schema. The
+// returned Fields hold every valid field; the error describes each rejected key
+// and wraps ErrReservedField, ErrUnregisteredField or ErrFieldType for each
+// kind of problem found. Keys the logger writes itself are always rejected.
+func (s *FieldSchema) Validate(fields Fields) (Fields, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	valid := make(Fields, len(fields))
+	var reserved, unregistered, mistyped []string
+	for _, key := range sortedKeys(fields) {
+		value := fields[key]
+		canonical := key
+		if c, ok := s.aliases[key]; ok {
+			canonical = c
+		}
+
+		if reservedKeys[canonical] {
+			reserved = append(reserved, key)
+			continue
+		}
+
+		typ, ok := s.types[canonical]
+		if !ok {
+			unregistered = append(unregistered, key)
+			continue
+		}
+		if !typ.accepts(value) {
+			mistyped = append(mistyped, fmt.Sprintf("%s (want %s, got %T)", key, typ, value))
+			continue
+		}
+		valid[canonical] = value
+	}
+
+	var errs fieldErrors
+	if len(reserved) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrReservedField, strings.Join(reserved, ", ")))
+	}
+	if len(unregistered) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrUnregisteredField, strings.Join(unregistered, ", ")))
+	}
+	if len(mistyped) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrFieldType, strings.Join(mistyped, ", ")))
+	}
+	if len(errs) > 0 {
+		return valid, errs
+	}
+	return valid, nil
+}
+
+// fieldErrors holds one error per kind of problem found by Validate
+type fieldErrors []error
+
+func (e fieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target
+func (e fieldErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts reports whether value may be logged under a key of type f
+func (f FieldType) accepts(value interface{}) bool {
+	switch v := value.(type) {
+	case time.Duration:
+		return f == AnyField || f == DurationField
+	case time.Time:
+		return f == AnyField || f == TimeField
+	case error:
+		return f == AnyField || f == ErrorField
+	case fmt.Stringer:
+		return f == AnyField || f == StringField
+	case nil:
+		return true
+	default:
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.String:
+			return f == AnyField || f == StringField
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return f == AnyField || f == IntField || f == FloatField
+		case reflect.Float32, reflect.Float64:
+			return f == AnyField || f == FloatField
+		case reflect.Bool:
+			return f == AnyField || f == BoolField
+		default:
+			return f == AnyField
+		}
+	}
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type schemaLogger struct {
+	FieldLogger
+	schema *FieldSchema
+	mode   SchemaMode
+}
+
+// NewSchemaLogger wraps logger so that every field added through WithFields is
+// checked against schema. Aliased keys are always renamed to their canonical
+// key; unknown keys are handled according to mode.
+func NewSchemaLogger(logger FieldLogger, schema *FieldSchema, mode SchemaMode) FieldLogger {
+	return schemaLogger{FieldLogger: logger, schema: schema, mode: mode}
+}
+
+// WithFields validates fields against the schema before attaching them
+func (s schemaLogger) WithFields(fields Fields) FieldLogger {
+	valid, err := s.schema.Validate(fields)
+	if err != nil {
+		if s.mode == SchemaStrict {
+			// skip this frame so that the warning points at the caller of WithFields
+			skipCallers(s.FieldLogger, 1).Warnf("dropping log fields: %v", err)
+		} else {
+			// keep rejected keys as they were given, only renaming known aliases.
+			// Reserved keys are dropped since they would duplicate the logger's own.
+			for k, v := range fields {
+				canonical := s.schema.canonical(k)
+				if _, ok := valid[canonical]; !ok && !reservedKeys[canonical] {
+					valid[k] = v
+				}
+			}
+		}
+	}
+
+	return schemaLogger{FieldLogger: s.FieldLogger.WithFields(valid), schema: s.schema, mode: s.mode}
+}
+
+func (s schemaLogger) skipCallers(frames int) Logger {
+	if fl, ok := skipCallers(s.FieldLogger, frames).(FieldLogger); ok {
+		s.FieldLogger = fl
+	}
+	return s
+}
+
+func (s schemaLogger) withoutCaller() Logger {
+	if fl, ok := withoutCaller(s.FieldLogger).(FieldLogger); ok {
+		s.FieldLogger = fl
+	}
+	return s
+}
+
+func (s *FieldSchema) canonical(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c, ok := s.aliases[key]; ok {
+		return c
+	}
+	return key
+}
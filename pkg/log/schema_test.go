@@ -0,0 +1,96 @@
+package log
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldSchemaValidate(t *testing.T) {
+	schema := DefaultFieldSchema().
+		Register("user_id", StringField).
+		Alias("user_id", "userId", "uid")
+
+	valid, err := schema.Validate(Fields{
+		"userId":    "abc",
+		DurationKey: 3 * time.Second,
+		CallKey:     "persistence.Query",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Fields{"user_id": "abc", DurationKey: 3 * time.Second, CallKey: "persistence.Query"}, valid)
+
+	valid, err = schema.Validate(Fields{"uid": 12, "colour": "red", ErrorKey: errors.New("boom")})
+	assert.ErrorIs(t, err, ErrUnregisteredField)
+	assert.ErrorIs(t, err, ErrFieldType)
+	assert.Contains(t, err.Error(), "colour")
+	assert.Contains(t, err.Error(), "uid (want string, got int)")
+	assert.Len(t, valid, 1)
+
+	_, err = schema.Validate(Fields{"uid": 12})
+	assert.ErrorIs(t, err, ErrFieldType)
+	assert.NotErrorIs(t, err, ErrUnregisteredField)
+}
+
+func TestSchemaLoggerModes(t *testing.T) {
+	schema := DefaultFieldSchema().Register("user_id", StringField).Alias("user_id", "uid")
+
+	buf := &syncBuffer{}
+	NewSchemaLogger(NewZeroLogger(buf), schema, SchemaStrict).
+		WithFields(Fields{"uid": "abc", "colour": "red"}).
+		Info("strict")
+	NewSchemaLogger(NewZeroLogger(buf), schema, SchemaPermissive).
+		WithFields(Fields{"uid": "abc", "colour": "red"}).
+		Info("permissive")
+
+	entries := buf.entries(t)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "warn", entries[0]["level"])
+	assert.Equal(t, "abc", entries[1]["user_id"])
+	assert.Nil(t, entries[1]["colour"])
+	assert.Nil(t, entries[1]["uid"])
+	assert.Equal(t, "abc", entries[2]["user_id"])
+	assert.Equal(t, "red", entries[2]["colour"])
+}
+
+func TestSchemaLoggerRejectsReservedKeys(t *testing.T) {
+	_, err := DefaultFieldSchema().Validate(Fields{MessageKey: "spoofed", SourceKey: "x.go:1", CallKey: "ok"})
+	assert.ErrorIs(t, err, ErrReservedField)
+	assert.NotErrorIs(t, err, ErrUnregisteredField)
+
+	for _, mode := range []SchemaMode{SchemaStrict, SchemaPermissive} {
+		buf := &syncBuffer{}
+		NewSchemaLogger(NewZeroLogger(buf), DefaultFieldSchema(), mode).
+			WithFields(Fields{MessageKey: "spoofed", TimestampKey: "yesterday"}).
+			Info("real")
+
+		entries := buf.entries(t)
+		last := entries[len(entries)-1]
+		assert.Equal(t, "real", last[MessageKey])
+		assert.Equal(t, 1, strings.Count(buf.buf.String(), `"msg":"real"`))
+		assert.NotContains(t, buf.buf.String(), "spoofed")
+		assert.NotContains(t, buf.buf.String(), "yesterday")
+	}
+}
+
+func TestSchemaLoggerWarnsAtCaller(t *testing.T) {
+	buf := &syncBuffer{}
+	strict := NewSchemaLogger(NewZeroLogger(buf), DefaultFieldSchema(), SchemaStrict)
+	deduped := NewDedupLogger(strict, time.Hour)
+
+	direct := nextLine(t)
+	strict.WithFields(Fields{"colour": "red"})
+	wrapped := nextLine(t)
+	deduped.WithFields(Fields{"colour": "red"}).Info("after")
+	require.NoError(t, deduped.Close())
+
+	entries := buf.entries(t)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "dropping log fields: unregistered log field: colour", entries[0][MessageKey])
+	assert.Equal(t, direct, entries[0][SourceKey])
+	assert.Equal(t, wrapped, entries[1][SourceKey])
+	assert.Equal(t, wrapped, entries[2][SourceKey], "the child logs at its caller too")
+}
@@ -11,11 +11,16 @@ import (
 )
 
 const (
+	ArgCountKey  = "args"
 	CallKey      = "call"
 	DurationKey  = "dur"
 	ErrorKey     = "err"
+	LevelKey     = "level"
 	SourceKey    = "source"
 	MessageKey   = "msg"
+	RepeatedKey  = "repeated"
+	RowsKey      = "rows"
+	StatementKey = "stmt"
 	TraceIDKey   = "traceId"
 	TimestampKey = "ts"
 )
@@ -27,16 +32,20 @@ const (
 
 type zerologger struct {
 	logger zerolog.Logger
+	// skip is the number of frames between the caller and the Logger method,
+	// or -1 to leave out SourceKey
+	skip int
 }
 
 type zerologEntry struct {
 	event *zerolog.Event
 }
 
-func NewZeroLogger(writer io.Writer) Logger {
+func NewZeroLogger(writer io.Writer) FieldLogger {
 	zerolog.TimeFieldFormat = TimestampFormat
 	zerolog.TimestampFieldName = TimestampKey
 	zerolog.MessageFieldName = MessageKey
+	zerolog.LevelFieldName = LevelKey
 	zerolog.DurationFieldInteger = true
 	zerolog.CallerFieldName = SourceKey
 	zerolog.CallerMarshalFunc = marshalCaller
@@ -44,10 +53,39 @@ func NewZeroLogger(writer io.Writer) Logger {
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
 
 	return zerologger{
-		logger: zerolog.New(writer).With().CallerWithSkipFrameCount(3).Stack().Timestamp().Logger(),
+		logger: zerolog.New(writer).With().Stack().Timestamp().Logger(),
 	}
 }
 
+// WithFields returns a child logger that adds the given fields to every entry
+func (z zerologger) WithFields(fields Fields) FieldLogger {
+	return zerologger{
+		logger: z.logger.With().Fields(map[string]interface{}(fields)).Logger(),
+		skip:   z.skip,
+	}
+}
+
+func (z zerologger) skipCallers(frames int) Logger {
+	if z.skip >= 0 {
+		z.skip += frames
+	}
+	return z
+}
+
+func (z zerologger) withoutCaller() Logger {
+	z.skip = -1
+	return z
+}
+
+// event adds SourceKey pointing at the code that called the Logger method
+func (z zerologger) event(e *zerolog.Event) *zerolog.Event {
+	if z.skip < 0 {
+		return e
+	}
+	// skip event and the Logger method
+	return e.Caller(2 + z.skip)
+}
+
 func marshalCaller(_ uintptr, file string, line int) string {
 	return afterLastSlash(file) + ":" + strconv.Itoa(line)
 }
@@ -65,61 +103,61 @@ func sprintlnn(args ...any) string {
 }
 
 func (z zerologger) Debug(args ...any) {
-	z.logger.Debug().Msg(fmt.Sprint(args...))
+	z.event(z.logger.Debug()).Msg(fmt.Sprint(args...))
 }
 
 func (z zerologger) Debugln(args ...any) {
-	z.logger.Debug().Msg(sprintlnn(args...))
+	z.event(z.logger.Debug()).Msg(sprintlnn(args...))
 }
 
 func (z zerologger) Debugf(format string, args ...interface{}) {
-	z.logger.Debug().Msgf(format, args...)
+	z.event(z.logger.Debug()).Msgf(format, args...)
 }
 
 func (z zerologger) Info(args ...any) {
-	z.logger.Info().Msg(fmt.Sprint(args...))
+	z.event(z.logger.Info()).Msg(fmt.Sprint(args...))
 }
 
 func (z zerologger) Infoln(args ...any) {
-	z.logger.Info().Msg(sprintlnn(args...))
+	z.event(z.logger.Info()).Msg(sprintlnn(args...))
 }
 
 func (z zerologger) Infof(format string, args ...interface{}) {
-	z.logger.Info().Msgf(format, args...)
+	z.event(z.logger.Info()).Msgf(format, args...)
 }
 
 func (z zerologger) Warn(args ...any) {
-	z.logger.Warn().Msg(fmt.Sprint(args...))
+	z.event(z.logger.Warn()).Msg(fmt.Sprint(args...))
 }
 
 func (z zerologger) Warnln(args ...any) {
-	z.logger.Warn().Msg(sprintlnn(args...))
+	z.event(z.logger.Warn()).Msg(sprintlnn(args...))
 }
 
 func (z zerologger) Warnf(format string, args ...interface{}) {
-	z.logger.Warn().Msgf(format, args...)
+	z.event(z.logger.Warn()).Msgf(format, args...)
 }
 
 func (z zerologger) Error(args ...any) {
-	z.logger.Error().Msg(fmt.Sprint(args...))
+	z.event(z.logger.Error()).Msg(fmt.Sprint(args...))
 }
 
 func (z zerologger) Errorln(args ...any) {
-	z.logger.Error().Msg(sprintlnn(args...))
+	z.event(z.logger.Error()).Msg(sprintlnn(args...))
 }
 
 func (z zerologger) Errorf(format string, args ...interface{}) {
-	z.logger.Error().Msgf(format, args...)
+	z.event(z.logger.Error()).Msgf(format, args...)
 }
 
 func (z zerologger) Fatal(args ...any) {
-	z.logger.Fatal().Msg(fmt.Sprint(args...))
+	z.event(z.logger.Fatal()).Msg(fmt.Sprint(args...))
 }
 
 func (z zerologger) Fatalln(args ...any) {
-	z.logger.Fatal().Msg(sprintlnn(args...))
+	z.event(z.logger.Fatal()).Msg(sprintlnn(args...))
 }
 
 func (z zerologger) Fatalf(format string, args ...interface{}) {
-	z.logger.Fatal().Msgf(format, args...)
+	z.event(z.logger.Fatal()).Msgf(format, args...)
 }
@@ -0,0 +1,53 @@
+package persistence
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoff computes exponentially growing waits between attempts
+type backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// duration returns the wait after the given attempt, starting at 1. The wait
+// doubles per attempt up to Max, and half of it is randomised so that replicas
+// starting together do not retry in lockstep.
+func (b backoff) duration(attempt int) time.Duration {
+	wait := b.Initial
+	for i := 1; i < attempt && (b.Max <= 0 || wait < b.Max); i++ {
+		wait *= 2
+	}
+	if b.Max > 0 && wait > b.Max {
+		wait = b.Max
+	}
+	if wait <= 1 {
+		return wait
+	}
+
+	half := wait / 2
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return half + time.Duration(jitterRand.Int63n(int64(half)+1))
+}
+
+// sleepContext waits for d or until ctx is done, whichever happens first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
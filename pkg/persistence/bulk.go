@@ -0,0 +1,253 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const defaultBulkBatchSize = 1000
+
+// RowIterator yields the rows loaded by BulkInsert. Next advances to the next
+// row and returns false when there are no more rows or iteration failed, in
+// which case Err reports why.
+type RowIterator interface {
+	Next() bool
+	Row() []interface{}
+	Err() error
+}
+
+type sliceRows struct {
+	rows [][]interface{}
+	row  []interface{}
+}
+
+// RowsFromSlice returns a RowIterator over rows
+func RowsFromSlice(rows [][]interface{}) RowIterator {
+	return &sliceRows{rows: rows}
+}
+
+func (s *sliceRows) Next() bool {
+	if len(s.rows) == 0 {
+		return false
+	}
+	s.row, s.rows = s.rows[0], s.rows[1:]
+	return true
+}
+
+func (s *sliceRows) Row() []interface{} { return s.row }
+func (s *sliceRows) Err() error         { return nil }
+
+type channelRows struct {
+	ctx context.Context
+	ch  <-chan []interface{}
+	row []interface{}
+	err error
+}
+
+// RowsFromChannel returns a RowIterator receiving rows from ch until it is
+// closed. Iteration fails with the context error if ctx is done first.
+func RowsFromChannel(ctx context.Context, ch <-chan []interface{}) RowIterator {
+	return &channelRows{ctx: ctx, ch: ch}
+}
+
+func (c *channelRows) Next() bool {
+	if c.err != nil {
+		return false
+	}
+	select {
+	case row, ok := <-c.ch:
+		c.row = row
+		return ok
+	case <-c.ctx.Done():
+		c.err = c.ctx.Err()
+		return false
+	}
+}
+
+func (c *channelRows) Row() []interface{} { return c.row }
+func (c *channelRows) Err() error         { return c.err }
+
+// BulkInsertOptions configure BulkInsert
+type BulkInsertOptions struct {
+	// BatchSize is the number of rows copied per transaction, defaults to 1000
+	BatchSize int
+	// ContinueOnError keeps loading the following batches when one fails
+	ContinueOnError bool
+	// Progress is called after every committed batch
+	Progress func(BulkInsertProgress)
+}
+
+// BulkInsertProgress reports how far BulkInsert got
+type BulkInsertProgress struct {
+	// Batch is the number of the batch just committed, starting at 1
+	Batch int
+	// Rows is the number of rows committed so far
+	Rows int64
+}
+
+// BulkInsertResult summarises a BulkInsert
+type BulkInsertResult struct {
+	Batches int
+	Rows    int64
+	// FailedBatches is the number of batches that were rolled back
+	FailedBatches int
+}
+
+// BatchError is returned when a batch could not be copied. Its rows were rolled back.
+type BatchError struct {
+	Batch int
+	// FirstRow is the position of the batch's first row in the input, starting at 0
+	FirstRow int64
+	Rows     int
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("problem copying batch %d (rows %d to %d): %v", e.Batch, e.FirstRow, e.FirstRow+int64(e.Rows)-1, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BulkInsertError lists every failed batch when BulkInsertOptions.ContinueOnError is set
+type BulkInsertError struct {
+	Batches []*BatchError
+	// Err is set when reading the rows failed after some batches had failed
+	Err error
+}
+
+func (e *BulkInsertError) Error() string {
+	msgs := make([]string, len(e.Batches), len(e.Batches)+1)
+	for i, b := range e.Batches {
+		msgs[i] = b.Error()
+	}
+	if e.Err != nil {
+		msgs = append(msgs, e.Err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the batch errors or the read error matches target
+func (e *BulkInsertError) Is(target error) bool {
+	for _, b := range e.Batches {
+		if errors.Is(b, target) {
+			return true
+		}
+	}
+	return e.Err != nil && errors.Is(e.Err, target)
+}
+
+// BulkInsert loads rows into columns of table with COPY. table may be schema
+// qualified. Rows are read into batches of opts.BatchSize, each copied and
+// committed in its own transaction, so a failure leaves the earlier batches
+// loaded. A failed batch is reported as a *BatchError; with ContinueOnError
+// loading goes on and every failure is reported in a *BulkInsertError. opts
+// may be nil for the defaults. When reading rows fails after batches failed
+// the read error is added to the *BulkInsertError.
+func (d DB) BulkInsert(ctx context.Context, table string, columns []string, rows RowIterator, opts *BulkInsertOptions) (BulkInsertResult, error) {
+	if opts == nil {
+		opts = &BulkInsertOptions{}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBulkBatchSize
+	}
+	copyStmt := copyInStatement(table, columns)
+
+	var result BulkInsertResult
+	var failed []*BatchError
+	var firstRow int64
+	batch := make([][]interface{}, 0, batchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result.Batches++
+		err := d.WithTx(ctx, nil, func(tx *sql.Tx) error {
+			return copyBatch(ctx, tx, copyStmt, batch)
+		})
+		if err != nil {
+			result.FailedBatches++
+			failed = append(failed, &BatchError{Batch: result.Batches, FirstRow: firstRow, Rows: len(batch), Err: err})
+		} else {
+			result.Rows += int64(len(batch))
+			if opts.Progress != nil {
+				opts.Progress(BulkInsertProgress{Batch: result.Batches, Rows: result.Rows})
+			}
+		}
+
+		firstRow += int64(len(batch))
+		batch = batch[:0]
+		if err != nil && (!opts.ContinueOnError || ctx.Err() != nil) {
+			return err
+		}
+		return nil
+	}
+
+	for rows.Next() {
+		batch = append(batch, rows.Row())
+		if len(batch) < batchSize {
+			continue
+		}
+		if err := flush(); err != nil {
+			return result, bulkInsertError(failed, opts.ContinueOnError)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("problem reading rows for bulk insert: %w", err)
+		if len(failed) > 0 {
+			return result, &BulkInsertError{Batches: failed, Err: err}
+		}
+		return result, err
+	}
+	if err := flush(); err != nil {
+		return result, bulkInsertError(failed, opts.ContinueOnError)
+	}
+
+	return result, bulkInsertError(failed, opts.ContinueOnError)
+}
+
+func bulkInsertError(failed []*BatchError, continueOnError bool) error {
+	switch {
+	case len(failed) == 0:
+		return nil
+	case !continueOnError:
+		return failed[0]
+	default:
+		return &BulkInsertError{Batches: failed}
+	}
+}
+
+// copyInStatement returns the COPY statement for table, which may be schema qualified
+func copyInStatement(table string, columns []string) string {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return pq.CopyInSchema(schema, name, columns...)
+	}
+	return pq.CopyIn(table, columns...)
+}
+
+func copyBatch(ctx context.Context, tx *sql.Tx, copyStmt string, batch [][]interface{}) error {
+	stmt, err := tx.PrepareContext(ctx, copyStmt)
+	if err != nil {
+		return fmt.Errorf("problem preparing copy: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, row := range batch {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			return err
+		}
+	}
+	// executing without arguments flushes the buffered rows and ends the copy
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	return nil
+}
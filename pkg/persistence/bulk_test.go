@@ -0,0 +1,152 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+// copyRecorder counts the rows copied per COPY statement through the fake driver
+type copyRecorder struct {
+	mu     sync.Mutex
+	rows   []int
+	copied int
+	// failRow makes copying the row with this first value fail
+	failRow interface{}
+}
+
+func (c *copyRecorder) exec(_ context.Context, query string, args []interface{}) (driver.Result, error) {
+	if !strings.HasPrefix(query, "COPY ") {
+		return driver.RowsAffected(0), nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(args) == 0 {
+		c.rows = append(c.rows, c.copied)
+		c.copied = 0
+		return driver.RowsAffected(0), nil
+	}
+	if c.failRow != nil && args[0] == c.failRow {
+		c.copied = 0
+		return nil, &pq.Error{Code: "23505", Constraint: "users_pkey"}
+	}
+	c.copied++
+	return driver.RowsAffected(0), nil
+}
+
+func numberedRows(n int) [][]interface{} {
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		rows[i] = []interface{}{int64(i), "name"}
+	}
+	return rows
+}
+
+func TestBulkInsert(t *testing.T) {
+	recorder := &copyRecorder{}
+	fake := &sqltest.Driver{Exec: recorder.exec}
+	db := newFakeDB(t, fake)
+
+	var progress []BulkInsertProgress
+	result, err := db.BulkInsert(context.Background(), "public.users", []string{"id", "name"}, RowsFromSlice(numberedRows(5)), &BulkInsertOptions{
+		BatchSize: 2,
+		Progress:  func(p BulkInsertProgress) { progress = append(progress, p) },
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, BulkInsertResult{Batches: 3, Rows: 5}, result)
+	assert.Equal(t, []int{2, 2, 1}, recorder.rows)
+	assert.Equal(t, []BulkInsertProgress{{Batch: 1, Rows: 2}, {Batch: 2, Rows: 4}, {Batch: 3, Rows: 5}}, progress)
+	assert.Equal(t, 3, fake.Count("BEGIN"))
+	assert.Equal(t, 3, fake.Count("COMMIT"))
+	assert.Contains(t, fake.Queries(), `COPY "public"."users" ("id", "name") FROM STDIN`)
+}
+
+func TestBulkInsertStopsAtFailedBatch(t *testing.T) {
+	recorder := &copyRecorder{failRow: int64(2)}
+	fake := &sqltest.Driver{Exec: recorder.exec}
+	db := newFakeDB(t, fake)
+
+	result, err := db.BulkInsert(context.Background(), "users", []string{"id", "name"}, RowsFromSlice(numberedRows(6)), &BulkInsertOptions{BatchSize: 2})
+
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 2, batchErr.Batch)
+	assert.Equal(t, int64(2), batchErr.FirstRow)
+	assert.ErrorIs(t, err, ErrUniqueViolation)
+	assert.Equal(t, BulkInsertResult{Batches: 2, Rows: 2, FailedBatches: 1}, result)
+	assert.Equal(t, 1, fake.Count("ROLLBACK"))
+}
+
+func TestBulkInsertContinueOnError(t *testing.T) {
+	recorder := &copyRecorder{failRow: int64(2)}
+	db := newFakeDB(t, &sqltest.Driver{Exec: recorder.exec})
+
+	result, err := db.BulkInsert(context.Background(), "users", []string{"id", "name"}, RowsFromSlice(numberedRows(6)), &BulkInsertOptions{
+		BatchSize:       2,
+		ContinueOnError: true,
+	})
+
+	var bulkErr *BulkInsertError
+	require.ErrorAs(t, err, &bulkErr)
+	require.Len(t, bulkErr.Batches, 1)
+	assert.ErrorIs(t, err, ErrUniqueViolation)
+	assert.Equal(t, BulkInsertResult{Batches: 3, Rows: 4, FailedBatches: 1}, result)
+}
+
+// failingRows yields its rows and then fails with err
+type failingRows struct {
+	RowIterator
+	err error
+}
+
+func (f failingRows) Err() error { return f.err }
+
+func TestBulkInsertContinueOnErrorKeepsBatchErrorsWhenReadingFails(t *testing.T) {
+	recorder := &copyRecorder{failRow: int64(0)}
+	db := newFakeDB(t, &sqltest.Driver{Exec: recorder.exec})
+	readErr := errors.New("source went away")
+
+	_, err := db.BulkInsert(context.Background(), "users", []string{"id", "name"}, failingRows{RowsFromSlice(numberedRows(3)), readErr}, &BulkInsertOptions{
+		BatchSize:       2,
+		ContinueOnError: true,
+	})
+
+	var bulkErr *BulkInsertError
+	require.ErrorAs(t, err, &bulkErr)
+	require.Len(t, bulkErr.Batches, 1)
+	assert.ErrorIs(t, err, ErrUniqueViolation)
+	assert.ErrorIs(t, err, readErr)
+	assert.ErrorContains(t, err, "source went away")
+}
+
+func TestBulkInsertFromChannel(t *testing.T) {
+	recorder := &copyRecorder{}
+	db := newFakeDB(t, &sqltest.Driver{Exec: recorder.exec})
+
+	ch := make(chan []interface{})
+	go func() {
+		defer close(ch)
+		for _, row := range numberedRows(3) {
+			ch <- row
+		}
+	}()
+
+	result, err := db.BulkInsert(context.Background(), "users", []string{"id", "name"}, RowsFromChannel(context.Background(), ch), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result.Rows)
+	assert.Equal(t, []int{3}, recorder.rows)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.BulkInsert(ctx, "users", []string{"id"}, RowsFromChannel(ctx, make(chan []interface{})), nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,132 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/koanf"
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+// ConfigurationError lists every problem found while parsing database
+// configuration, so that a deployment can be fixed in one go
+type ConfigurationError struct {
+	Problems []error
+}
+
+func (e *ConfigurationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the problems matches target, so that callers can
+// still match ErrMissingConfiguration and ErrInvalidConfiguration
+func (e *ConfigurationError) Is(target error) bool {
+	for _, p := range e.Problems {
+		if errors.Is(p, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// configReader reads typed values from koanf.Koanf configuration, collecting
+// every problem instead of stopping at the first
+type configReader struct {
+	logger   log.Logger
+	cfg      *koanf.Koanf
+	problems []error
+	// credentialsProvided skips the DB_USER and DB_PASSWORD requirements
+	credentialsProvided bool
+}
+
+func newConfigReader(logger log.Logger, cfg *koanf.Koanf) *configReader {
+	return &configReader{logger: logger, cfg: cfg}
+}
+
+// err returns a *ConfigurationError holding every problem found, or nil
+func (r *configReader) err() error {
+	if len(r.problems) == 0 {
+		return nil
+	}
+	return &ConfigurationError{Problems: r.problems}
+}
+
+func (r *configReader) addProblem(err error) {
+	r.problems = append(r.problems, err)
+}
+
+func (r *configReader) string(key string) string {
+	return r.cfg.String(key)
+}
+
+func (r *configReader) required(key string) string {
+	value := r.cfg.String(key)
+	if len(value) == 0 {
+		r.addProblem(newRequiredEnvironmentVariableError(key))
+	}
+	return value
+}
+
+func (r *configReader) stringOrDefault(key, description, def string) string {
+	value := r.cfg.String(key)
+	if len(value) == 0 {
+		r.logger.Infof("no configuration found for %s, defaulting to: %s", description, def)
+		return def
+	}
+	return value
+}
+
+func (r *configReader) nonNegativeInt(key string, def int) int {
+	raw := r.cfg.String(key)
+	if len(raw) == 0 {
+		r.logger.Infof("no configuration found for %s, defaulting to: %d", key, def)
+		return def
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		r.addProblem(newInvalidConfigurationError(key, raw))
+		return def
+	}
+	return value
+}
+
+func (r *configReader) nonNegativeDuration(key string, def time.Duration) time.Duration {
+	raw := r.cfg.String(key)
+	if len(raw) == 0 {
+		r.logger.Infof("no configuration found for %s, defaulting to: %s", key, def)
+		return def
+	}
+
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		r.addProblem(newInvalidConfigurationError(key, raw))
+		return def
+	}
+	return value
+}
+
+// positiveDuration is like nonNegativeDuration but also rejects zero
+func (r *configReader) positiveDuration(key string, def time.Duration) time.Duration {
+	value := r.nonNegativeDuration(key, def)
+	if value == 0 {
+		r.addProblem(newInvalidConfigurationError(key, r.cfg.String(key)))
+		return def
+	}
+	return value
+}
+
+func newRequiredEnvironmentVariableError(name string) error {
+	return fmt.Errorf("%w: %s", ErrMissingConfiguration, name)
+}
+
+func newInvalidConfigurationError(name, value string) error {
+	return fmt.Errorf("%w for %s: %s", ErrInvalidConfiguration, name, value)
+}
@@ -0,0 +1,197 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+// connector is a driver.Connector that authenticates every new connection with
+// the latest credentials from a CredentialsProvider. When the credentials
+// change, connections opened with the previous ones are discarded once they
+// are returned to the pool, so in-flight queries and transactions finish on
+// the old connections while new work moves to new ones.
+type connector struct {
+	cfg      dbConfig
+	provider CredentialsProvider
+	logger   log.Logger
+
+	mu          sync.RWMutex
+	credentials Credentials
+	generation  uint64
+}
+
+// newConnector fetches the initial credentials from provider
+func newConnector(ctx context.Context, logger log.Logger, cfg dbConfig, provider CredentialsProvider) (*connector, error) {
+	credentials, err := provider.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connector{cfg: cfg, provider: provider, logger: logger, credentials: credentials}, nil
+}
+
+// Connect opens a connection with the current credentials. If authentication
+// fails the provider is queried again, and the connection retried once when it
+// returned different credentials.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	credentials, generation := c.current()
+	conn, err := c.connect(ctx, credentials)
+	if err != nil && isAuthenticationFailure(err) {
+		changed, refreshErr := c.refresh(ctx)
+		if refreshErr != nil {
+			c.logger.Warnf("problem refreshing database credentials after authentication failure: %v", refreshErr)
+		}
+		if changed {
+			credentials, generation = c.current()
+			conn, err = c.connect(ctx, credentials)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &rotatingConn{Conn: conn, connector: c, generation: generation}, nil
+}
+
+// Driver returns the lib/pq driver
+func (c *connector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+func (c *connector) connect(ctx context.Context, credentials Credentials) (driver.Conn, error) {
+	cfg := c.cfg
+	if len(credentials.User) > 0 {
+		cfg.User = credentials.User
+	}
+	cfg.Password = credentials.Password
+
+	pqConnector, err := pq.NewConnector(newPostgresConnectionString(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return pqConnector.Connect(ctx)
+}
+
+func (c *connector) current() (Credentials, uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.credentials, c.generation
+}
+
+func (c *connector) isCurrent(generation uint64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation == generation
+}
+
+// refresh queries the provider, reporting whether the credentials changed
+func (c *connector) refresh(ctx context.Context) (bool, error) {
+	credentials, err := c.provider.Credentials(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if credentials == c.credentials {
+		return false, nil
+	}
+	c.credentials = credentials
+	c.generation++
+	c.logger.Infof("database credentials changed, draining connections opened with previous credentials")
+	return true, nil
+}
+
+// refreshEvery queries the provider on every interval until ctx is done
+func (c *connector) refreshEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.refresh(ctx); err != nil && ctx.Err() == nil {
+				c.logger.Warnf("problem refreshing database credentials: %v", err)
+			}
+		}
+	}
+}
+
+// isAuthenticationFailure reports whether err is postgres rejecting the credentials
+func isAuthenticationFailure(err error) bool {
+	return hasCode(err, "28P01", "28000")
+}
+
+// rotatingConn is a connection opened by connector. It reports itself invalid
+// once the credentials it was opened with are replaced, so that database/sql
+// closes it instead of reusing it.
+type rotatingConn struct {
+	driver.Conn
+	connector  *connector
+	generation uint64
+}
+
+func (r *rotatingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := r.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return r.Conn.Prepare(query)
+}
+
+func (r *rotatingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := r.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return nil, errors.New("driver does not support BeginTx")
+}
+
+func (r *rotatingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := r.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (r *rotatingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := r.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (r *rotatingConn) Ping(ctx context.Context) error {
+	if p, ok := r.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession is called before a pooled connection is reused
+func (r *rotatingConn) ResetSession(ctx context.Context) error {
+	if !r.connector.isCurrent(r.generation) {
+		return driver.ErrBadConn
+	}
+	if s, ok := r.Conn.(driver.SessionResetter); ok {
+		return s.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid is called before a connection is returned to the pool
+func (r *rotatingConn) IsValid() bool {
+	if !r.connector.isCurrent(r.generation) {
+		return false
+	}
+	if v, ok := r.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
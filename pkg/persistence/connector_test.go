@@ -0,0 +1,68 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+)
+
+// rotatingProvider returns whatever password it was last given
+type rotatingProvider struct {
+	mu       sync.Mutex
+	password string
+}
+
+func (r *rotatingProvider) Credentials(context.Context) (Credentials, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Credentials{User: "boom", Password: r.password}, nil
+}
+
+func (r *rotatingProvider) rotate(password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.password = password
+}
+
+type stubConn struct {
+	driver.Conn
+}
+
+func TestConnectorDrainsConnectionsAfterRotation(t *testing.T) {
+	ctx := context.Background()
+	provider := &rotatingProvider{password: "first"}
+	c, err := newConnector(ctx, logtest.NewNopLogger(), dbConfig{}, provider)
+	require.NoError(t, err)
+
+	_, generation := c.current()
+	conn := &rotatingConn{Conn: stubConn{}, connector: c, generation: generation}
+	assert.True(t, conn.IsValid())
+	assert.NoError(t, conn.ResetSession(ctx))
+
+	changed, err := c.refresh(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed, "unchanged credentials should not drain connections")
+
+	provider.rotate("second")
+	changed, err = c.refresh(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	credentials, _ := c.current()
+	assert.Equal(t, "second", credentials.Password)
+	assert.False(t, conn.IsValid())
+	assert.ErrorIs(t, conn.ResetSession(ctx), driver.ErrBadConn)
+}
+
+func TestIsAuthenticationFailure(t *testing.T) {
+	assert.True(t, isAuthenticationFailure(&pq.Error{Code: "28P01"}))
+	assert.True(t, isAuthenticationFailure(&pq.Error{Code: "28000"}))
+	assert.False(t, isAuthenticationFailure(&pq.Error{Code: "23505"}))
+	assert.False(t, isAuthenticationFailure(driver.ErrBadConn))
+}
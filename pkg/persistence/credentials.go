@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zhughes3/elliot/pkg/secret"
+)
+
+// Credentials are used to authenticate new connections to the database. An
+// empty User keeps the user from configuration.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+// CredentialsProvider returns the current database credentials. It is queried
+// when the DB is opened, whenever a new connection fails to authenticate and,
+// if DB_CREDENTIALS_REFRESH_INTERVAL is set, on that interval.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+type staticCredentials struct {
+	credentials Credentials
+}
+
+// NewStaticCredentialsProvider returns a CredentialsProvider that always returns the given credentials
+func NewStaticCredentialsProvider(user, password string) CredentialsProvider {
+	return staticCredentials{Credentials{User: user, Password: password}}
+}
+
+func (s staticCredentials) Credentials(context.Context) (Credentials, error) {
+	return s.credentials, nil
+}
+
+type keyVaultCredentials struct {
+	vault      secret.KeyVault
+	user       string
+	secretName string
+}
+
+// NewKeyVaultCredentialsProvider returns a CredentialsProvider that reads the
+// password for user from the vault secret named secretName
+func NewKeyVaultCredentialsProvider(vault secret.KeyVault, user, secretName string) CredentialsProvider {
+	return keyVaultCredentials{vault: vault, user: user, secretName: secretName}
+}
+
+func (k keyVaultCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	password, found, err := k.vault.ReadSecret(ctx, k.secretName)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("problem reading database password secret %s: %w", k.secretName, err)
+	}
+	if !found {
+		return Credentials{}, fmt.Errorf("%w: %s", ErrPasswordSecretNotFound, k.secretName)
+	}
+
+	return Credentials{User: k.user, Password: password}, nil
+}
+
+// credentialsProviderFor returns the CredentialsProvider described by cfg
+func credentialsProviderFor(cfg dbConfig, vault secret.KeyVault) (CredentialsProvider, error) {
+	if len(cfg.PasswordSecret) == 0 {
+		return NewStaticCredentialsProvider(cfg.User, cfg.Password), nil
+	}
+	if vault == nil {
+		return nil, fmt.Errorf("%w: DB_PASSWORD_SECRET is set but no key vault was provided", ErrInvalidConfiguration)
+	}
+	return NewKeyVaultCredentialsProvider(vault, cfg.User, cfg.PasswordSecret), nil
+}
@@ -0,0 +1,71 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/secret"
+)
+
+func TestKeyVaultCredentialsProvider(t *testing.T) {
+	ctx := context.Background()
+	vault := secret.NewMockKeyVault(gomock.NewController(t))
+	vault.EXPECT().ReadSecret(gomock.Eq(ctx), gomock.Eq("db-password")).Return("from-vault", true, nil)
+
+	provider, err := credentialsProviderFor(dbConfig{User: "boom", PasswordSecret: "db-password"}, vault)
+	require.NoError(t, err)
+
+	credentials, err := provider.Credentials(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{User: "boom", Password: "from-vault"}, credentials)
+}
+
+func TestKeyVaultCredentialsProviderErrors(t *testing.T) {
+	ctx := context.Background()
+	vault := secret.NewMockKeyVault(gomock.NewController(t))
+	provider := NewKeyVaultCredentialsProvider(vault, "boom", "db-password")
+
+	vault.EXPECT().ReadSecret(gomock.Eq(ctx), gomock.Eq("db-password")).Return("", false, nil)
+	_, err := provider.Credentials(ctx)
+	assert.ErrorIs(t, err, ErrPasswordSecretNotFound)
+
+	vault.EXPECT().ReadSecret(gomock.Eq(ctx), gomock.Eq("db-password")).Return("", false, errors.New("forbidden"))
+	_, err = provider.Credentials(ctx)
+	assert.ErrorContains(t, err, "forbidden")
+
+	_, err = credentialsProviderFor(dbConfig{PasswordSecret: "db-password"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidConfiguration)
+}
+
+func TestParseDBConfigurationWithPasswordSecret(t *testing.T) {
+	dbCfg, err := parseDBConfiguration(logtest.NewNopLogger(), newTestConfig(t, map[string]interface{}{
+		"DB_USER":            "boom",
+		"DB_NAME":            "some_db_name",
+		"DB_PASSWORD_SECRET": "db-password",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "db-password", dbCfg.PasswordSecret)
+	assert.Empty(t, dbCfg.Password)
+}
+
+func TestNewSubscriberWithPasswordSecret(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig(t, map[string]interface{}{
+		"DB_USER":            "boom",
+		"DB_NAME":            "some_db_name",
+		"DB_PASSWORD_SECRET": "db-password",
+	})
+
+	_, err := NewSubscriber(ctx, logtest.NewNopLogger(), cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfiguration)
+
+	vault := secret.NewMockKeyVault(gomock.NewController(t))
+	vault.EXPECT().ReadSecret(gomock.Eq(ctx), gomock.Eq("db-password")).Return("", false, errors.New("forbidden"))
+	_, err = NewSubscriberWithKeyVault(ctx, logtest.NewNopLogger(), cfg, vault)
+	assert.ErrorContains(t, err, "forbidden")
+}
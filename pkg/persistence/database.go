@@ -1,18 +1,29 @@
 package persistence
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/knadh/koanf"
 	"github.com/zhughes3/elliot/pkg/log"
+	"github.com/zhughes3/elliot/pkg/secret"
 
 	_ "github.com/lib/pq"
 )
 
 // DB is a simple wrapper around sql.DB
 type DB struct {
-	DB *sql.DB
+	DB      *sql.DB
+	cleanup func()
+	tracker *tracker
+	// replicas is nil when no read replicas are configured
+	replicas *replicaSet
+
+	logger             log.Logger
+	slowQueryThreshold time.Duration
 }
 
 type dbConfig struct {
@@ -21,61 +32,186 @@ type dbConfig struct {
 	Host     string
 	Port     string
 	Name     string
+
+	// PasswordSecret names a secret.KeyVault secret holding the password
+	PasswordSecret string
+	// CredentialsRefreshInterval is how often credentials are re-queried, zero disables it
+	CredentialsRefreshInterval time.Duration
+	// SlowQueryThreshold is the duration after which queries are logged at warn, zero disables it
+	SlowQueryThreshold time.Duration
+
+	// SSLRootCert, SSLCert and SSLKey hold either a file path or PEM encoded material
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	ApplicationName string
+	ConnectTimeout  time.Duration
+	SearchPath      string
+	// Params are passed through to the driver as additional connection parameters
+	Params map[string]string
+
+	// Replicas are read replicas sharing the credentials and settings of the primary
+	Replicas             []replicaHost
+	ReplicaCheckInterval time.Duration
+
+	Pool  poolConfig
+	Retry retryConfig
 }
 
 // NewDB opens a conn to database and verifies connection
 func NewDB(logger log.Logger, cfg *koanf.Koanf) (DB, error) {
+	return NewDBContext(context.Background(), logger, cfg)
+}
+
+// NewDBContext opens a conn to database and verifies connection, giving up when
+// ctx is done. Configuration errors wrap ErrMissingConfiguration or ErrInvalidConfiguration.
+func NewDBContext(ctx context.Context, logger log.Logger, cfg *koanf.Koanf) (DB, error) {
+	return NewDBWithKeyVault(ctx, logger, cfg, nil)
+}
+
+// NewDBWithKeyVault opens a conn to database like NewDBContext. When
+// DB_PASSWORD_SECRET is configured the password is read from vault under that
+// name instead of from DB_PASSWORD, and read again whenever it is rejected.
+func NewDBWithKeyVault(ctx context.Context, logger log.Logger, cfg *koanf.Koanf, vault secret.KeyVault) (DB, error) {
 	dbCfg, err := parseDBConfiguration(logger, cfg)
 	if err != nil {
-		return DB{}, fmt.Errorf("problem parsing db configuration: %v", err)
+		return DB{}, fmt.Errorf("problem parsing db configuration: %w", err)
+	}
+
+	provider, err := credentialsProviderFor(dbCfg, vault)
+	if err != nil {
+		return DB{}, err
+	}
+
+	return NewPostgresDBWithCredentials(ctx, logger, dbCfg, provider)
+}
+
+// NewDBWithCredentials opens a conn to database like NewDBContext, taking
+// credentials from provider instead of DB_USER and DB_PASSWORD. Rotated
+// credentials are picked up without restarting.
+func NewDBWithCredentials(ctx context.Context, logger log.Logger, cfg *koanf.Koanf, provider CredentialsProvider) (DB, error) {
+	r := newConfigReader(logger, cfg)
+	r.credentialsProvided = true
+	dbCfg, err := parseConfiguration(r)
+	if err != nil {
+		return DB{}, fmt.Errorf("problem parsing db configuration: %w", err)
 	}
 
-	return NewPostgresDB(logger, dbCfg)
+	return NewPostgresDBWithCredentials(ctx, logger, dbCfg, provider)
 }
 
 // NewDBFromConn creates wrapper for sql.DB conn handler
 func NewDBFromConn(db *sql.DB) DB {
-	return DB{db}
+	return DB{DB: db, tracker: newTracker()}
 }
 
-// parseDBConfiguration attempts to create a dbConfig instance from koanf.Koanf configuration
+// Close closes the underlying sql.DB and read replicas, and removes any ssl
+// material written to disk
+func (d DB) Close() error {
+	err := d.DB.Close()
+	if d.replicas != nil {
+		if replicaErr := d.replicas.close(); err == nil {
+			err = replicaErr
+		}
+	}
+	if d.cleanup != nil {
+		d.cleanup()
+	}
+	return err
+}
+
+// parseDBConfiguration attempts to create a dbConfig instance from koanf.Koanf
+// configuration. Every problem found is reported in a single *ConfigurationError.
 func parseDBConfiguration(logger log.Logger, cfg *koanf.Koanf) (dbConfig, error) {
-	host := cfg.String("DB_HOST")
-	if len(host) == 0 {
-		logger.Infof("no configuration found for database host, defaulting to: %s", "localhost")
-		host = "localhost"
+	return parseConfiguration(newConfigReader(logger, cfg))
+}
+
+func parseConfiguration(r *configReader) (dbConfig, error) {
+	var dbCfg dbConfig
+	if databaseURL := r.string("DATABASE_URL"); len(databaseURL) > 0 {
+		r.logger.Infof("configuration found for DATABASE_URL, ignoring discrete database configuration")
+		dbCfg = parseDatabaseURLConfiguration(r, databaseURL)
+	} else {
+		dbCfg = parseConnectionConfiguration(r)
+	}
+	if passwordSecret := r.string("DB_PASSWORD_SECRET"); len(passwordSecret) > 0 {
+		dbCfg.PasswordSecret = passwordSecret
+	}
+	dbCfg.Pool = parsePoolConfiguration(r)
+	dbCfg.Retry = parseRetryConfiguration(r)
+	dbCfg.CredentialsRefreshInterval = r.nonNegativeDuration("DB_CREDENTIALS_REFRESH_INTERVAL", 0)
+	dbCfg.SlowQueryThreshold = r.nonNegativeDuration("DB_SLOW_QUERY_THRESHOLD", defaultSlowQueryThreshold)
+	if replicaHosts := r.string("DB_REPLICA_HOSTS"); len(replicaHosts) > 0 {
+		port := dbCfg.Port
+		if len(port) == 0 {
+			port = "5432"
+		}
+		dbCfg.Replicas = parseReplicaHosts(r, "DB_REPLICA_HOSTS", replicaHosts, port)
+	}
+	dbCfg.ReplicaCheckInterval = r.nonNegativeDuration("DB_REPLICA_CHECK_INTERVAL", defaultReplicaCheckInterval)
+
+	if err := r.err(); err != nil {
+		return dbConfig{}, err
 	}
+	return dbCfg, nil
+}
 
-	port := cfg.String("DB_PORT")
-	if len(port) == 0 {
-		logger.Infof("no configuration found for database port, defaulting to: %s", "5432")
-		port = "5432"
+// parseDatabaseURLConfiguration creates a dbConfig from the DATABASE_URL configuration
+func parseDatabaseURLConfiguration(r *configReader, databaseURL string) dbConfig {
+	dbCfg, err := parseDatabaseURL(databaseURL)
+	if err != nil {
+		r.addProblem(err)
+		return dbConfig{}
+	}
+	if len(dbCfg.SSLMode) == 0 {
+		dbCfg.SSLMode = sslModeDisable
+	}
+	if !isValidSSLMode(dbCfg.SSLMode) {
+		r.addProblem(newInvalidConfigurationError("sslmode in DATABASE_URL", dbCfg.SSLMode))
 	}
+	return dbCfg
+}
+
+// parseConnectionConfiguration creates a dbConfig from the discrete DB_* configuration
+func parseConnectionConfiguration(r *configReader) dbConfig {
+	host := r.stringOrDefault("DB_HOST", "database host", "localhost")
 
-	user := cfg.String("DB_USER")
-	if len(user) == 0 {
-		return dbConfig{}, newRequiredEnvironmentVariableError("DB_USER")
+	port := r.stringOrDefault("DB_PORT", "database port", "5432")
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		r.addProblem(newInvalidConfigurationError("DB_PORT", port))
 	}
 
-	password := cfg.String("DB_PASSWORD")
-	if len(password) == 0 {
-		return dbConfig{}, newRequiredEnvironmentVariableError("DB_PASSWORD")
+	var user, password string
+	if r.credentialsProvided {
+		user = r.string("DB_USER")
+	} else {
+		user = r.required("DB_USER")
+		if len(r.string("DB_PASSWORD_SECRET")) == 0 {
+			password = r.required("DB_PASSWORD")
+		}
 	}
+	name := r.required("DB_NAME")
 
-	name := cfg.String("DB_NAME")
-	if len(name) == 0 {
-		return dbConfig{}, newRequiredEnvironmentVariableError("DB_NAME")
+	sslMode := r.stringOrDefault("DB_SSLMODE", "database sslmode", sslModeDisable)
+	if !isValidSSLMode(sslMode) {
+		r.addProblem(newInvalidConfigurationError("DB_SSLMODE", sslMode))
 	}
 
 	return dbConfig{
-		User:     user,
-		Password: password,
-		Name:     name,
-		Host:     host,
-		Port:     port,
-	}, nil
-}
+		User:        user,
+		Password:    password,
+		Name:        name,
+		Host:        host,
+		Port:        port,
+		SSLMode:     sslMode,
+		SSLRootCert: r.string("DB_SSLROOTCERT"),
+		SSLCert:     r.string("DB_SSLCERT"),
+		SSLKey:      r.string("DB_SSLKEY"),
 
-func newRequiredEnvironmentVariableError(name string) error {
-	return fmt.Errorf("missing required environment variable: %s", name)
+		ApplicationName: r.string("DB_APPLICATION_NAME"),
+		ConnectTimeout:  r.nonNegativeDuration("DB_CONNECT_TIMEOUT", 0),
+		SearchPath:      r.string("DB_SEARCH_PATH"),
+	}
 }
@@ -0,0 +1,133 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrMissingConfiguration is wrapped by errors for required configuration that was not provided
+	ErrMissingConfiguration = errors.New("missing required environment variable")
+	// ErrInvalidConfiguration is wrapped by errors for configuration values that cannot be used
+	ErrInvalidConfiguration = errors.New("invalid value")
+	// ErrPasswordSecretNotFound is returned when DB_PASSWORD_SECRET names a secret missing from the key vault
+	ErrPasswordSecretNotFound = errors.New("database password secret not found in key vault")
+)
+
+// Classifications of postgres errors, matched with errors.Is against the
+// errors returned by ClassifyError
+var (
+	ErrUniqueViolation     = errors.New("unique violation")
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+	ErrNotNullViolation    = errors.New("not null violation")
+	ErrCheckViolation      = errors.New("check violation")
+	ErrQueryCanceled       = errors.New("query canceled")
+	ErrConnection          = errors.New("database connection error")
+)
+
+// Error is a classified postgres error. Use errors.As to read the constraint,
+// table and column it concerns.
+type Error struct {
+	// Kind is one of the classification errors such as ErrUniqueViolation
+	Kind       error
+	Code       string
+	Constraint string
+	Table      string
+	Column     string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Is matches the classification of e
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the driver error, usually a *pq.Error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError wraps err in an *Error when it is one of the classified
+// postgres errors and returns it unchanged otherwise
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		kind := kindForCode(pqErr.Code)
+		if kind == nil {
+			return err
+		}
+		return &Error{
+			Kind:       kind,
+			Code:       string(pqErr.Code),
+			Constraint: pqErr.Constraint,
+			Table:      pqErr.Table,
+			Column:     pqErr.Column,
+			Err:        err,
+		}
+	}
+
+	// context.DeadlineExceeded implements net.Error, so it must be told apart
+	// from connection failures first. The context error stays matchable.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Kind: ErrQueryCanceled, Err: err}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return &Error{Kind: ErrConnection, Err: err}
+	}
+
+	return err
+}
+
+func kindForCode(code pq.ErrorCode) error {
+	switch code {
+	case "23505":
+		return ErrUniqueViolation
+	case "23503":
+		return ErrForeignKeyViolation
+	case "23502":
+		return ErrNotNullViolation
+	case "23514":
+		return ErrCheckViolation
+	case "57014":
+		return ErrQueryCanceled
+	// admin_shutdown, crash_shutdown and cannot_connect_now terminate the session
+	case "57P01", "57P02", "57P03":
+		return ErrConnection
+	}
+	if code.Class() == "08" {
+		return ErrConnection
+	}
+	return nil
+}
+
+// hasCode reports whether err is a *pq.Error with one of the given SQLSTATE codes
+func hasCode(err error, codes ...pq.ErrorCode) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	for _, code := range codes {
+		if pqErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	tcs := []struct {
+		err      error
+		expected error
+	}{
+		{&pq.Error{Code: "23505"}, ErrUniqueViolation},
+		{&pq.Error{Code: "23503"}, ErrForeignKeyViolation},
+		{&pq.Error{Code: "23502"}, ErrNotNullViolation},
+		{&pq.Error{Code: "23514"}, ErrCheckViolation},
+		{&pq.Error{Code: "57014"}, ErrQueryCanceled},
+		{&pq.Error{Code: "08006"}, ErrConnection},
+		{&pq.Error{Code: "57P01"}, ErrConnection},
+		{driver.ErrBadConn, ErrConnection},
+		{fmt.Errorf("problem committing transaction: %w", &pq.Error{Code: "23505"}), ErrUniqueViolation},
+		{context.Canceled, ErrQueryCanceled},
+		{context.DeadlineExceeded, ErrQueryCanceled},
+		{fmt.Errorf("problem reading rows: %w", context.Canceled), ErrQueryCanceled},
+		{fmt.Errorf("problem reading rows: %w", context.DeadlineExceeded), ErrQueryCanceled},
+	}
+
+	for _, tc := range tcs {
+		assert.ErrorIs(t, ClassifyError(tc.err), tc.expected, "%v", tc.err)
+	}
+}
+
+func TestClassifyErrorContextErrorsAreNotConnectionErrors(t *testing.T) {
+	for _, err := range []error{context.DeadlineExceeded, fmt.Errorf("x: %w", context.DeadlineExceeded), context.Canceled} {
+		classified := ClassifyError(err)
+		assert.False(t, errors.Is(classified, ErrConnection), "%v", err)
+		assert.ErrorIs(t, classified, err)
+	}
+}
+
+func TestClassifyErrorDetails(t *testing.T) {
+	pqErr := &pq.Error{Code: "23505", Message: "duplicate key", Constraint: "users_email_key", Table: "users", Column: "email"}
+	err := ClassifyError(pqErr)
+
+	var classified *Error
+	require.ErrorAs(t, err, &classified)
+	assert.Equal(t, "users_email_key", classified.Constraint)
+	assert.Equal(t, "users", classified.Table)
+	assert.Equal(t, "email", classified.Column)
+	assert.Equal(t, "unique violation: pq: duplicate key", err.Error())
+
+	var unwrapped *pq.Error
+	assert.ErrorAs(t, err, &unwrapped)
+	assert.False(t, errors.Is(err, ErrForeignKeyViolation))
+	assert.Same(t, err, ClassifyError(err))
+}
+
+func TestClassifyErrorPassesThrough(t *testing.T) {
+	other := errors.New("boom")
+	assert.Same(t, other, ClassifyError(other))
+	assert.Nil(t, ClassifyError(nil))
+
+	syntax := &pq.Error{Code: "42601"}
+	assert.Same(t, syntax, ClassifyError(syntax))
+}
@@ -0,0 +1,99 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	checkTimeout = 2 * time.Second
+	// readinessCheckFailed is reported instead of the driver error, which can
+	// reveal hosts and users to unauthenticated callers
+	readinessCheckFailed = "database check failed"
+)
+
+// PoolStats are the connection pool statistics of a DB
+type PoolStats struct {
+	MaxOpenConnections int           `json:"maxOpenConnections"`
+	OpenConnections    int           `json:"openConnections"`
+	InUse              int           `json:"inUse"`
+	Idle               int           `json:"idle"`
+	WaitCount          int64         `json:"waitCount"`
+	WaitDuration       time.Duration `json:"waitDurationMs"`
+	MaxIdleClosed      int64         `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64         `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64         `json:"maxLifetimeClosed"`
+}
+
+// MarshalJSON writes WaitDuration in milliseconds
+func (p PoolStats) MarshalJSON() ([]byte, error) {
+	type stats PoolStats
+	s := stats(p)
+	s.WaitDuration = p.WaitDuration / time.Millisecond
+	return json.Marshal(s)
+}
+
+// readiness is the body written by ReadinessHandler
+type readiness struct {
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Pool   PoolStats `json:"pool"`
+}
+
+// Check verifies that the database answers a trivial query within a couple of
+// seconds, or ctx's deadline if that is sooner
+func (d DB) Check(ctx context.Context) error {
+	ctx, done, err := d.begin(ctx, "health check", true)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var one int
+	return ClassifyError(d.DB.QueryRowContext(ctx, "SELECT 1").Scan(&one))
+}
+
+// Stats returns the connection pool statistics
+func (d DB) Stats() PoolStats {
+	s := d.DB.Stats()
+	return PoolStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDuration:       s.WaitDuration,
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
+
+// ReadinessHandler returns an http.Handler reporting the result of Check and
+// the pool statistics as JSON, with status 503 when the check fails. The
+// response only says the check failed; the cause is logged.
+func (d DB) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := readiness{Status: "ok"}
+		status := http.StatusOK
+		if err := d.Check(r.Context()); err != nil {
+			d.warnf("readiness check failed: %v", err)
+			body.Status = "unavailable"
+			body.Error = readinessCheckFailed
+			status = http.StatusServiceUnavailable
+		}
+		body.Pool = d.Stats()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(body); err != nil && d.logger != nil {
+			d.logger.Warnf("problem writing readiness response: %v", err)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	fake := &sqltest.Driver{Query: func(string, []interface{}) (driver.Rows, error) {
+		return &sqltest.Rows{ColumnNames: []string{"?column?"}, Values: [][]driver.Value{{int64(1)}}}, nil
+	}}
+	db := newFakeDB(t, fake)
+
+	rec := httptest.NewRecorder()
+	db.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "ok", body["status"])
+	assert.Contains(t, body["pool"], "inUse")
+	assert.Contains(t, body["pool"], "waitDurationMs")
+}
+
+func TestReadinessHandlerUnavailable(t *testing.T) {
+	fake := &sqltest.Driver{Query: func(string, []interface{}) (driver.Rows, error) {
+		return nil, driver.ErrBadConn
+	}}
+	logger := logtest.NewRecorder()
+	db := newFakeDB(t, fake).WithQueryLogging(logger, 0)
+
+	rec := httptest.NewRecorder()
+	db.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var body readiness
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "unavailable", body.Status)
+	assert.Equal(t, readinessCheckFailed, body.Error)
+	assert.NotContains(t, rec.Body.String(), driver.ErrBadConn.Error())
+
+	entries := logger.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "warn", entries[0].Level)
+	assert.Contains(t, entries[0].Message, driver.ErrBadConn.Error())
+}
@@ -0,0 +1,198 @@
+// Package sqltest provides a scripted database/sql driver for testing code
+// built on persistence without a postgres server.
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"testing"
+)
+
+// Statement is a statement run through a Driver. Transactions are recorded as
+// the statements BEGIN, COMMIT and ROLLBACK.
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+// Driver is a driver.Connector whose statements are answered by its Exec and
+// Query hooks and recorded in order. Nil hooks answer with no rows.
+type Driver struct {
+	Exec  func(ctx context.Context, query string, args []interface{}) (driver.Result, error)
+	Query func(query string, args []interface{}) (driver.Rows, error)
+	// Commit is called after COMMIT is recorded, its error fails the commit
+	Commit func() error
+	// ConnectErr makes opening new connections fail, see SetConnectErr
+	ConnectErr error
+
+	mu         sync.Mutex
+	statements []Statement
+}
+
+// Open returns a sql.DB using d, closed when the test ends
+func (d *Driver) Open(t testing.TB) *sql.DB {
+	db := sql.OpenDB(d)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// Statements returns every statement run so far
+func (d *Driver) Statements() []Statement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Statement(nil), d.statements...)
+}
+
+// Count returns how often query was run so far
+func (d *Driver) Count(query string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, s := range d.statements {
+		if s.Query == query {
+			n++
+		}
+	}
+	return n
+}
+
+// SetConnectErr changes ConnectErr while the driver is in use
+func (d *Driver) SetConnectErr(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ConnectErr = err
+}
+
+// Queries returns the text of every statement run so far
+func (d *Driver) Queries() []string {
+	statements := d.Statements()
+	queries := make([]string, len(statements))
+	for i, s := range statements {
+		queries[i] = s.Query
+	}
+	return queries
+}
+
+func (d *Driver) record(query string, args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, Statement{Query: query, Args: values})
+	return values
+}
+
+// Connect opens a new connection, or fails with ConnectErr
+func (d *Driver) Connect(context.Context) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ConnectErr != nil {
+		return nil, d.ConnectErr
+	}
+	return &conn{d: d}, nil
+}
+
+// Driver is not used since database/sql opens connections through Connect
+func (d *Driver) Driver() driver.Driver { return nil }
+
+type conn struct {
+	d *Driver
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) { return &stmt{c: c, query: query}, nil }
+func (c *conn) Close() error                              { return nil }
+func (c *conn) Begin() (driver.Tx, error)                 { return c.BeginTx(context.Background(), driver.TxOptions{}) }
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.d.record("BEGIN", nil)
+	return tx{d: c.d}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := c.d.record(query, args)
+	if c.d.Exec == nil {
+		return driver.RowsAffected(0), nil
+	}
+	return c.d.Exec(ctx, query, values)
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := c.d.record(query, args)
+	if c.d.Query == nil {
+		return &Rows{}, nil
+	}
+	return c.d.Query(query, values)
+}
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+// Exec and Query are never called since the context variants are implemented
+func (s *stmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (s *stmt) Query([]driver.Value) (driver.Rows, error)  { return nil, driver.ErrSkip }
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.c.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.c.QueryContext(ctx, s.query, args)
+}
+
+type tx struct {
+	d *Driver
+}
+
+func (t tx) Commit() error {
+	t.d.record("COMMIT", nil)
+	if t.d.Commit != nil {
+		return t.d.Commit()
+	}
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.d.record("ROLLBACK", nil)
+	return nil
+}
+
+// Rows returns Values row by row for ColumnNames
+type Rows struct {
+	ColumnNames []string
+	Values      [][]driver.Value
+}
+
+// NewRows returns Rows with a single column holding value
+func NewRows(column string, values ...driver.Value) *Rows {
+	rows := &Rows{ColumnNames: []string{column}}
+	for _, v := range values {
+		rows.Values = append(rows.Values, []driver.Value{v})
+	}
+	return rows
+}
+
+// Columns returns ColumnNames
+func (r *Rows) Columns() []string { return r.ColumnNames }
+
+// Close does nothing
+func (r *Rows) Close() error { return nil }
+
+// Next copies the next row into dest
+func (r *Rows) Next(dest []driver.Value) error {
+	if len(r.Values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.Values[0])
+	r.Values = r.Values[1:]
+	return nil
+}
@@ -0,0 +1,200 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+const (
+	defaultLeaderRetryInterval = 5 * time.Second
+	defaultLeaderCheckInterval = 5 * time.Second
+)
+
+// LockKey derives the 64 bit postgres advisory lock key for name
+func LockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// Lock is a session advisory lock held on a dedicated connection
+type Lock interface {
+	// Conn returns the connection holding the lock, for work that must run in
+	// the locking session
+	Conn() *sql.Conn
+	// Release unlocks and returns the connection to the pool. If unlocking
+	// fails the connection is closed instead, which also releases the lock.
+	Release(ctx context.Context) error
+}
+
+type sessionLock struct {
+	conn *sql.Conn
+	name string
+	key  int64
+}
+
+// Lock blocks until it holds the session advisory lock name, or ctx is done.
+// The lock stays held until it is released, so it must not be leaked.
+func (d DB) Lock(ctx context.Context, name string) (Lock, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("problem getting connection for lock %s: %w", name, err)
+	}
+
+	key := LockKey(name)
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		// the lock may have been granted before the cancellation arrived
+		discard(conn)
+		return nil, fmt.Errorf("problem acquiring lock %s: %w", name, ClassifyError(err))
+	}
+	return &sessionLock{conn: conn, name: name, key: key}, nil
+}
+
+// TryLock acquires the session advisory lock name if no other session holds
+// it. acquired is false when it is held elsewhere.
+func (d DB) TryLock(ctx context.Context, name string) (lock Lock, acquired bool, err error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("problem getting connection for lock %s: %w", name, err)
+	}
+
+	key := LockKey(name)
+	acquired, err = tryLock(ctx, conn, key)
+	if err != nil {
+		discard(conn)
+		return nil, false, fmt.Errorf("problem acquiring lock %s: %w", name, ClassifyError(err))
+	}
+	if !acquired {
+		_ = conn.Close()
+		return nil, false, nil
+	}
+	return &sessionLock{conn: conn, name: name, key: key}, true, nil
+}
+
+func (l *sessionLock) Conn() *sql.Conn {
+	return l.conn
+}
+
+func (l *sessionLock) Release(ctx context.Context) error {
+	if _, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		discard(l.conn)
+		return fmt.Errorf("problem releasing lock %s: %w", l.name, ClassifyError(err))
+	}
+	return l.conn.Close()
+}
+
+// LockTx blocks until tx holds the transaction advisory lock name. It is
+// released when tx commits or rolls back.
+func LockTx(ctx context.Context, tx *sql.Tx, name string) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", LockKey(name)); err != nil {
+		return fmt.Errorf("problem acquiring lock %s: %w", name, ClassifyError(err))
+	}
+	return nil
+}
+
+// TryLockTx acquires the transaction advisory lock name if no other session
+// holds it. It is released when tx commits or rolls back.
+func TryLockTx(ctx context.Context, tx *sql.Tx, name string) (bool, error) {
+	var acquired bool
+	err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", LockKey(name)).Scan(&acquired)
+	if err != nil {
+		return false, fmt.Errorf("problem acquiring lock %s: %w", name, ClassifyError(err))
+	}
+	return acquired, nil
+}
+
+func tryLock(ctx context.Context, conn *sql.Conn, key int64) (bool, error) {
+	var acquired bool
+	err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired)
+	return acquired, err
+}
+
+// discard closes conn instead of returning it to the pool, so that a session
+// lock it may still hold is released
+func discard(conn *sql.Conn) {
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	_ = conn.Close()
+}
+
+// LeaderOptions configure Lead
+type LeaderOptions struct {
+	// RetryInterval is how often a follower tries to become leader, defaults to 5s
+	RetryInterval time.Duration
+	// CheckInterval is how often the leader verifies its connection, defaults to 5s
+	CheckInterval time.Duration
+	// OnElected is called when leadership is acquired. ctx is cancelled once it
+	// is lost. It is called from the election loop, so long running work must
+	// be started in a goroutine.
+	OnElected func(ctx context.Context)
+	// OnDemoted is called when leadership is lost or given up
+	OnDemoted func()
+}
+
+// Lead takes part in the election of a leader among every caller using name,
+// until ctx is done. The leader holds the session advisory lock name on a
+// dedicated connection, and loses leadership when that connection fails.
+// Leadership is given up when ctx is done, and ctx's error returned.
+func (d DB) Lead(ctx context.Context, name string, opts LeaderOptions) error {
+	retryInterval := opts.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = defaultLeaderRetryInterval
+	}
+	checkInterval := opts.CheckInterval
+	if checkInterval <= 0 {
+		checkInterval = defaultLeaderCheckInterval
+	}
+
+	for {
+		lock, acquired, err := d.TryLock(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			d.warnf("problem taking part in leader election %s: %v", name, err)
+		}
+		if acquired {
+			d.lead(ctx, name, lock.(*sessionLock), checkInterval, opts)
+		}
+
+		if err := sleepContext(ctx, retryInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// lead holds leadership until ctx is done or the lock's connection fails
+func (d DB) lead(ctx context.Context, name string, lock *sessionLock, checkInterval time.Duration, opts LeaderOptions) {
+	leaderCtx, demote := context.WithCancel(ctx)
+	defer demote()
+
+	if opts.OnElected != nil {
+		opts.OnElected(leaderCtx)
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// the lock must be released even though ctx is done
+			if err := lock.Release(context.Background()); err != nil {
+				d.warnf("problem giving up leadership of %s: %v", name, err)
+			}
+			if opts.OnDemoted != nil {
+				opts.OnDemoted()
+			}
+			return
+		case <-ticker.C:
+			if err := lock.conn.PingContext(ctx); err != nil && ctx.Err() == nil {
+				d.warnf("lost leadership of %s: %v", name, err)
+				demote()
+				discard(lock.conn)
+				if opts.OnDemoted != nil {
+					opts.OnDemoted()
+				}
+				return
+			}
+		}
+	}
+}
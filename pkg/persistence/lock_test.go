@@ -0,0 +1,120 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+// lockServer scripts the results of advisory lock functions through the fake driver
+type lockServer struct {
+	mu        sync.Mutex
+	available []bool
+	unlocks   int
+}
+
+func (s *lockServer) query(query string, _ []interface{}) (driver.Rows, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acquired := true
+	if len(s.available) > 0 {
+		acquired, s.available = s.available[0], s.available[1:]
+	}
+	return &sqltest.Rows{ColumnNames: []string{"acquired"}, Values: [][]driver.Value{{acquired}}}, nil
+}
+
+func (s *lockServer) exec(_ context.Context, query string, _ []interface{}) (driver.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.Contains(query, "pg_advisory_unlock") {
+		s.unlocks++
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s *lockServer) unlockCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unlocks
+}
+
+func TestLockKey(t *testing.T) {
+	assert.Equal(t, LockKey("nightly-report"), LockKey("nightly-report"))
+	assert.NotEqual(t, LockKey("nightly-report"), LockKey("hourly-report"))
+}
+
+func TestTryLock(t *testing.T) {
+	server := &lockServer{available: []bool{true, false}}
+	fake := &sqltest.Driver{Query: server.query, Exec: server.exec}
+	db := newFakeDB(t, fake)
+
+	lock, acquired, err := db.TryLock(context.Background(), "nightly-report")
+	require.NoError(t, err)
+	require.True(t, acquired)
+	assert.Equal(t, 1, db.Stats().InUse, "the lock holds its connection")
+
+	_, acquired, err = db.TryLock(context.Background(), "nightly-report")
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	require.NoError(t, lock.Release(context.Background()))
+	assert.Equal(t, 1, server.unlockCount())
+	assert.Equal(t, 0, db.Stats().InUse)
+}
+
+func TestLockTx(t *testing.T) {
+	server := &lockServer{available: []bool{false}}
+	fake := &sqltest.Driver{Query: server.query, Exec: server.exec}
+	db := newFakeDB(t, fake)
+
+	err := db.WithTx(context.Background(), nil, func(tx *sql.Tx) error {
+		if err := LockTx(context.Background(), tx, "nightly-report"); err != nil {
+			return err
+		}
+		acquired, err := TryLockTx(context.Background(), tx, "hourly-report")
+		assert.False(t, acquired)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Contains(t, fake.Queries(), "SELECT pg_advisory_xact_lock($1)")
+}
+
+func TestLead(t *testing.T) {
+	server := &lockServer{available: []bool{false, true}}
+	db := newFakeDB(t, &sqltest.Driver{Query: server.query, Exec: server.exec})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	elected := make(chan context.Context, 1)
+	demoted := make(chan struct{})
+	result := make(chan error)
+	go func() {
+		result <- db.Lead(ctx, "scheduler", LeaderOptions{
+			RetryInterval: time.Millisecond,
+			CheckInterval: time.Millisecond,
+			OnElected:     func(leaderCtx context.Context) { elected <- leaderCtx },
+			OnDemoted:     func() { close(demoted) },
+		})
+	}()
+
+	var leaderCtx context.Context
+	select {
+	case leaderCtx = <-elected:
+	case <-time.After(time.Second):
+		t.Fatal("never elected")
+	}
+	assert.NoError(t, leaderCtx.Err())
+
+	cancel()
+	assert.ErrorIs(t, <-result, context.Canceled)
+	<-demoted
+	assert.Error(t, leaderCtx.Err())
+	assert.Equal(t, 1, server.unlockCount())
+}
@@ -0,0 +1,280 @@
+// Package migrate applies versioned SQL migrations, typically embedded in the
+// service binary with embed.FS, to a Postgres database.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/zhughes3/elliot/pkg/log"
+	"github.com/zhughes3/elliot/pkg/persistence"
+)
+
+const defaultTable = "schema_migrations"
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration was edited afterwards
+	ErrChecksumMismatch = errors.New("applied migration has changed")
+	// ErrUnknownMigration is returned when the database has a version applied that is not among the migrations
+	ErrUnknownMigration = errors.New("applied migration is unknown")
+	// ErrNoDownMigration is returned when rolling back a migration without a down file
+	ErrNoDownMigration = errors.New("migration has no down file")
+)
+
+// Options configure a Migrator
+type Options struct {
+	// Dir is the directory of the fs.FS holding the migrations, defaults to "."
+	Dir string
+	// Table records applied migrations, defaults to schema_migrations. It may be schema qualified.
+	Table string
+	// DryRun logs the statements that would run without executing them
+	DryRun bool
+}
+
+// Status describes a migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and rolls back migrations
+type Migrator interface {
+	// Up applies every pending migration in version order
+	Up(ctx context.Context) error
+	// DownTo rolls back every applied migration with a version greater than version
+	DownTo(ctx context.Context, version int64) error
+	// Status lists every known migration and whether it has been applied
+	Status(ctx context.Context) ([]Status, error)
+}
+
+type migrator struct {
+	logger     log.Logger
+	db         persistence.DB
+	migrations []Migration
+	table      string
+	// lockName depends on the table so that migrators for different tables do not block each other
+	lockName string
+	dryRun   bool
+}
+
+// New loads the migrations in fsys and returns a Migrator for db
+func New(logger log.Logger, db persistence.DB, fsys fs.FS, opts Options) (Migrator, error) {
+	dir := opts.Dir
+	if len(dir) == 0 {
+		dir = "."
+	}
+	table := opts.Table
+	if len(table) == 0 {
+		table = defaultTable
+	}
+
+	migrations, err := Load(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrator{
+		logger:     logger,
+		db:         db,
+		migrations: migrations,
+		table:      persistence.QuoteTable(table),
+		lockName:   "migrate:" + table,
+		dryRun:     opts.DryRun,
+	}, nil
+}
+
+func (m *migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		if err := m.ensureTable(ctx, conn); err != nil {
+			return err
+		}
+
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			m.logger.Infof("applying migration %d_%s", migration.Version, migration.Name)
+			insert := fmt.Sprintf("INSERT INTO %s (version, name, checksum) VALUES ($1, $2, $3)", m.table)
+			err := m.exec(ctx, conn, migration.Up, insert, migration.Version, migration.Name, migration.Checksum())
+			if err != nil {
+				return fmt.Errorf("problem applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (m *migrator) DownTo(ctx context.Context, version int64) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version <= version {
+				break
+			}
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if len(migration.Down) == 0 {
+				return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, migration.Version, migration.Name)
+			}
+
+			m.logger.Infof("rolling back migration %d_%s", migration.Version, migration.Name)
+			remove := fmt.Sprintf("DELETE FROM %s WHERE version = $1", m.table)
+			if err := m.exec(ctx, conn, migration.Down, remove, migration.Version); err != nil {
+				return fmt.Errorf("problem rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Status reads without taking the migration lock, so it does not wait for a
+// running migration and may not reflect it yet
+func (m *migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("problem getting migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+	return statuses, nil
+}
+
+// withLock runs fn on a dedicated connection holding a session advisory lock,
+// so that replicas starting together apply migrations one at a time
+func (m *migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	lock, err := m.db.Lock(ctx, m.lockName)
+	if err != nil {
+		return fmt.Errorf("problem acquiring migration lock: %w", err)
+	}
+	defer func() {
+		// the lock must be released even if ctx was cancelled
+		if err := lock.Release(context.Background()); err != nil {
+			m.logger.Warnf("problem releasing migration lock: %v", err)
+		}
+	}()
+
+	return fn(lock.Conn())
+}
+
+func (m *migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version    BIGINT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	checksum   TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`, m.table)
+
+	if m.dryRun {
+		var exists bool
+		err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", m.table).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("problem checking migrations table: %w", err)
+		}
+		if !exists {
+			m.logger.Infof("dry run, would execute:\n%s", create)
+		}
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, create); err != nil {
+		return fmt.Errorf("problem creating migrations table: %w", err)
+	}
+	return nil
+}
+
+// applied returns the applied versions and when they were applied, verifying
+// that each still matches its migration
+func (m *migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	applied := map[int64]time.Time{}
+
+	var exists bool
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", m.table).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("problem checking migrations table: %w", err)
+	}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, checksum, applied_at FROM %s", m.table))
+	if err != nil {
+		return nil, fmt.Errorf("problem reading applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	known := map[int64]Migration{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for rows.Next() {
+		var version int64
+		var checksum string
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &checksum, &appliedAt); err != nil {
+			return nil, fmt.Errorf("problem reading applied migrations: %w", err)
+		}
+
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: version %d", ErrUnknownMigration, version)
+		}
+		if migration.Checksum() != checksum {
+			return nil, fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, migration.Version, migration.Name)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("problem reading applied migrations: %w", err)
+	}
+
+	return applied, nil
+}
+
+// exec runs a migration statement and its bookkeeping statement in one transaction
+func (m *migrator) exec(ctx context.Context, conn *sql.Conn, statement, bookkeeping string, args ...interface{}) error {
+	if m.dryRun {
+		m.logger.Infof("dry run, would execute:\n%s", statement)
+		return nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,164 @@
+package migrate
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/persistence"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+// migrationsDB scripts a database whose migrations table exists when tableExists
+// is true and holds the given versions with their checksums
+func migrationsDB(t *testing.T, tableExists bool, checksums map[int64]string) (*sqltest.Driver, persistence.DB) {
+	fake := &sqltest.Driver{Query: func(query string, _ []interface{}) (driver.Rows, error) {
+		switch {
+		case strings.Contains(query, "to_regclass"):
+			return sqltest.NewRows("exists", tableExists), nil
+		case strings.HasPrefix(query, "SELECT version"):
+			rows := &sqltest.Rows{ColumnNames: []string{"version", "checksum", "applied_at"}}
+			for version, checksum := range checksums {
+				rows.Values = append(rows.Values, []driver.Value{version, checksum, time.Now()})
+			}
+			return rows, nil
+		}
+		return &sqltest.Rows{}, nil
+	}}
+	return fake, persistence.NewDBFromConn(fake.Open(t))
+}
+
+func newTestMigrator(t *testing.T, db persistence.DB, opts Options) Migrator {
+	opts.Dir = "testdata/migrations"
+	m, err := New(logtest.NewNopLogger(), db, testMigrations, opts)
+	require.NoError(t, err)
+	return m
+}
+
+func checksumOf(t *testing.T, version int64) string {
+	migrations, err := Load(testMigrations, "testdata/migrations")
+	require.NoError(t, err)
+	for _, m := range migrations {
+		if m.Version == version {
+			return m.Checksum()
+		}
+	}
+	t.Fatalf("no migration %d", version)
+	return ""
+}
+
+func TestUpAppliesPendingMigrations(t *testing.T) {
+	fake, db := migrationsDB(t, true, map[int64]string{1: checksumOf(t, 1)})
+
+	require.NoError(t, newTestMigrator(t, db, Options{}).Up(context.Background()))
+
+	queries := fake.Queries()
+	assert.Equal(t, "SELECT pg_advisory_lock($1)", queries[0])
+	assert.Contains(t, queries[1], `CREATE TABLE IF NOT EXISTS "schema_migrations"`)
+	assert.Equal(t, []string{
+		"BEGIN",
+		"ALTER TABLE users ADD COLUMN name TEXT;\n",
+		`INSERT INTO "schema_migrations" (version, name, checksum) VALUES ($1, $2, $3)`,
+		"COMMIT",
+		"SELECT pg_advisory_unlock($1)",
+	}, queries[len(queries)-5:])
+
+	statements := fake.Statements()
+	assert.Equal(t, []interface{}{int64(2), "add_user_name", checksumOf(t, 2)}, statements[len(statements)-3].Args)
+}
+
+func TestUpDetectsChangedMigrations(t *testing.T) {
+	fake, db := migrationsDB(t, true, map[int64]string{1: "edited"})
+
+	err := newTestMigrator(t, db, Options{}).Up(context.Background())
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.NotContains(t, fake.Queries(), "BEGIN")
+	assert.Contains(t, fake.Queries(), "SELECT pg_advisory_unlock($1)", "the lock is released on errors")
+
+	_, db = migrationsDB(t, true, map[int64]string{1: checksumOf(t, 1), 9: "unknown"})
+	assert.ErrorIs(t, newTestMigrator(t, db, Options{}).Up(context.Background()), ErrUnknownMigration)
+}
+
+func TestDownToRollsBackMigrations(t *testing.T) {
+	fake, db := migrationsDB(t, true, map[int64]string{1: checksumOf(t, 1)})
+
+	require.NoError(t, newTestMigrator(t, db, Options{}).DownTo(context.Background(), 0))
+	queries := fake.Queries()
+	assert.Equal(t, []string{
+		"BEGIN",
+		"DROP TABLE users;\n",
+		`DELETE FROM "schema_migrations" WHERE version = $1`,
+		"COMMIT",
+		"SELECT pg_advisory_unlock($1)",
+	}, queries[len(queries)-5:])
+
+	_, db = migrationsDB(t, true, map[int64]string{1: checksumOf(t, 1), 2: checksumOf(t, 2)})
+	err := newTestMigrator(t, db, Options{}).DownTo(context.Background(), 0)
+	assert.ErrorIs(t, err, ErrNoDownMigration)
+}
+
+func TestDryRunOnlyLogsStatements(t *testing.T) {
+	fake, db := migrationsDB(t, false, nil)
+	logger := logtest.NewRecorder()
+	m, err := New(logger, db, testMigrations, Options{Dir: "testdata/migrations", DryRun: true})
+	require.NoError(t, err)
+
+	require.NoError(t, m.Up(context.Background()))
+
+	for _, query := range fake.Queries() {
+		assert.NotContains(t, query, "CREATE TABLE")
+		assert.NotEqual(t, "BEGIN", query)
+	}
+	var logged []string
+	for _, entry := range logger.Entries() {
+		if strings.HasPrefix(entry.Message, "dry run") {
+			logged = append(logged, entry.Message)
+		}
+	}
+	require.Len(t, logged, 3)
+	assert.Contains(t, logged[0], `CREATE TABLE IF NOT EXISTS "schema_migrations"`)
+	assert.Contains(t, logged[1], "CREATE TABLE users")
+	assert.Contains(t, logged[2], "ALTER TABLE users")
+}
+
+func TestStatusDoesNotTakeTheLock(t *testing.T) {
+	fake, db := migrationsDB(t, true, map[int64]string{1: checksumOf(t, 1)})
+
+	statuses, err := newTestMigrator(t, db, Options{}).Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+
+	for _, query := range fake.Queries() {
+		assert.NotContains(t, query, "pg_advisory")
+	}
+}
+
+func TestSchemaQualifiedTable(t *testing.T) {
+	fake, db := migrationsDB(t, true, nil)
+
+	require.NoError(t, newTestMigrator(t, db, Options{Table: "ops.schema_migrations"}).Up(context.Background()))
+	assert.Contains(t, fake.Queries(), `INSERT INTO "ops"."schema_migrations" (version, name, checksum) VALUES ($1, $2, $3)`)
+}
+
+func TestUpFailsWithoutTheLock(t *testing.T) {
+	fake := &sqltest.Driver{Exec: func(_ context.Context, query string, _ []interface{}) (driver.Result, error) {
+		if strings.Contains(query, "pg_advisory_lock") {
+			return nil, context.Canceled
+		}
+		return driver.RowsAffected(0), nil
+	}}
+	db := persistence.NewDBFromConn(fake.Open(t))
+
+	err := newTestMigrator(t, db, Options{}).Up(context.Background())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"SELECT pg_advisory_lock($1)"}, fake.Queries(), "nothing runs without the lock")
+	assert.Equal(t, []interface{}{persistence.LockKey("migrate:schema_migrations")}, fake.Statements()[0].Args)
+	assert.Zero(t, db.Stats().OpenConnections, "a connection that may hold the lock is not reused")
+}
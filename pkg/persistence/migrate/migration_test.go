@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"embed"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed testdata/migrations/*.sql
+var testMigrations embed.FS
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testMigrations, "testdata/migrations")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "create_users", migrations[0].Name)
+	assert.Contains(t, migrations[0].Up, "CREATE TABLE users")
+	assert.Contains(t, migrations[0].Down, "DROP TABLE users")
+	assert.Equal(t, int64(2), migrations[1].Version)
+	assert.Empty(t, migrations[1].Down)
+	assert.NotEqual(t, migrations[0].Checksum(), migrations[1].Checksum())
+}
+
+func TestLoadErrors(t *testing.T) {
+	tcs := map[string]fstest.MapFS{
+		"missing up": {
+			"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		},
+		"no direction": {
+			"0001_create_users.sql": {Data: []byte("CREATE TABLE users ();")},
+		},
+		"bad version": {
+			"one_create_users.up.sql": {Data: []byte("CREATE TABLE users ();")},
+		},
+		"duplicate version": {
+			"0001_create_users.up.sql":  {Data: []byte("CREATE TABLE users ();")},
+			"0001_create_orders.up.sql": {Data: []byte("CREATE TABLE orders ();")},
+		},
+	}
+
+	for name, fsys := range tcs {
+		_, err := Load(fsys, ".")
+		assert.Error(t, err, name)
+	}
+}
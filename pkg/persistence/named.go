@@ -0,0 +1,260 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrMissingParameter is returned when a named parameter has no value in the bound struct or map
+var ErrMissingParameter = errors.New("missing value for named parameter")
+
+// namedQuery is a query split around its :name parameters, len(parts) == len(names)+1
+type namedQuery struct {
+	parts []string
+	names []string
+}
+
+// maxNamedQueries caps the parsed query cache so that queries built at runtime
+// cannot grow it without bound. Queries beyond the cap are parsed on every call.
+const maxNamedQueries = 1000
+
+var namedQueryCache = struct {
+	sync.RWMutex
+	queries map[string]*namedQuery
+}{queries: map[string]*namedQuery{}}
+
+// Named rewrites the :name parameters in query to postgres positional
+// parameters and returns the matching arguments taken from arg. arg is a
+// struct, or pointer to one, whose fields are named like in QueryEach, or a
+// map with string keys. Slice values other than []byte and driver.Valuer
+// implementations are expanded into one parameter per element, so
+// "id IN (:ids)" works for lists. A name used more than once is bound once.
+// Casts (::), array slices (a[lo:hi]), quoted strings including E'...' and
+// dollar quoted strings, identifiers, and comments are left untouched.
+func Named(query string, arg interface{}) (string, []interface{}, error) {
+	parsed := parseNamedQuery(query)
+	if len(parsed.names) == 0 {
+		return query, nil, nil
+	}
+
+	lookup, err := namedValues(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	var args []interface{}
+	placeholders := map[string]string{}
+	for i, name := range parsed.names {
+		b.WriteString(parsed.parts[i])
+
+		placeholder, ok := placeholders[name]
+		if !ok {
+			value, found := lookup(name)
+			if !found {
+				return "", nil, fmt.Errorf("%w: %s", ErrMissingParameter, name)
+			}
+			values, err := expandValue(name, value)
+			if err != nil {
+				return "", nil, err
+			}
+			positions := make([]string, len(values))
+			for j := range values {
+				positions[j] = "$" + strconv.Itoa(len(args)+j+1)
+			}
+			args = append(args, values...)
+			placeholder = strings.Join(positions, ", ")
+			placeholders[name] = placeholder
+		}
+		b.WriteString(placeholder)
+	}
+	b.WriteString(parsed.parts[len(parsed.parts)-1])
+
+	return b.String(), args, nil
+}
+
+// NamedExecContext executes a statement with :name parameters bound from arg, see Named
+func (d DB) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	query, args, err := Named(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return d.ExecContext(ctx, query, args...)
+}
+
+// NamedQueryContext executes a statement with :name parameters bound from arg, see Named
+func (d DB) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sql.Rows, error) {
+	query, args, err := Named(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return d.QueryContext(ctx, query, args...)
+}
+
+// parseNamedQuery splits query around its :name parameters, caching the result per query
+func parseNamedQuery(query string) *namedQuery {
+	namedQueryCache.RLock()
+	cached, ok := namedQueryCache.queries[query]
+	namedQueryCache.RUnlock()
+	if ok {
+		return cached
+	}
+
+	parsed := &namedQuery{}
+	start := 0
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"':
+			i = skipUntil(query, i+1, string(c))
+		case (c == 'E' || c == 'e') && strings.HasPrefix(query[i+1:], "'") && (i == 0 || !isNamePart(query[i-1])):
+			i = skipEscapeString(query, i+2)
+		case c == '$' && (i == 0 || !isNamePart(query[i-1])):
+			if tag, ok := dollarQuoteTag(query[i:]); ok {
+				i = skipUntil(query, i+len(tag), tag) + len(tag) - 1
+			}
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			i = skipUntil(query, i+2, "\n")
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			i = skipUntil(query, i+2, "*/") + 1
+		case c == ':' && strings.HasPrefix(query[i:], "::"):
+			i++
+		case c == ':' && i > 0 && isNamePart(query[i-1]):
+			// an array slice bound such as a[lo:hi]
+		case c == ':' && i+1 < len(query) && isNameStart(query[i+1]):
+			end := i + 2
+			for end < len(query) && isNamePart(query[end]) {
+				end++
+			}
+			parsed.parts = append(parsed.parts, query[start:i])
+			parsed.names = append(parsed.names, query[i+1:end])
+			start = end
+			i = end - 1
+		}
+	}
+	parsed.parts = append(parsed.parts, query[start:])
+
+	namedQueryCache.Lock()
+	defer namedQueryCache.Unlock()
+	if cached, ok := namedQueryCache.queries[query]; ok {
+		return cached
+	}
+	if len(namedQueryCache.queries) < maxNamedQueries {
+		namedQueryCache.queries[query] = parsed
+	}
+	return parsed
+}
+
+// skipUntil returns the index of the first byte of end at or after i, or the last index of s
+func skipUntil(s string, i int, end string) int {
+	if n := strings.Index(s[i:], end); n >= 0 {
+		return i + n
+	}
+	return len(s) - 1
+}
+
+// skipEscapeString returns the index of the quote closing an E'...' string whose
+// body starts at i, stepping over backslash escapes and doubled quotes
+func skipEscapeString(s string, i int) int {
+	for ; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '\'' && strings.HasPrefix(s[i+1:], "'"):
+			i++
+		case s[i] == '\'':
+			return i
+		}
+	}
+	return len(s) - 1
+}
+
+// dollarQuoteTag returns the opening $tag$ of a dollar quoted string at the
+// start of s. Positional parameters such as $1 are not tags.
+func dollarQuoteTag(s string) (string, bool) {
+	end := 1
+	if end < len(s) && isNameStart(s[end]) {
+		for end < len(s) && isNamePart(s[end]) {
+			end++
+		}
+	}
+	if end < len(s) && s[end] == '$' {
+		return s[:end+1], true
+	}
+	return "", false
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+// namedValues returns a lookup of parameter values by name from a struct or map
+func namedValues(arg interface{}) (func(name string) (interface{}, bool), error) {
+	v := reflect.ValueOf(arg)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errors.New("cannot bind named parameters from a nil pointer")
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		return func(name string) (interface{}, bool) {
+			value := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if !value.IsValid() {
+				return nil, false
+			}
+			return value.Interface(), true
+		}, nil
+	case v.Kind() == reflect.Struct:
+		info, err := structInfoFor(v.Type())
+		if err != nil {
+			return nil, err
+		}
+		return func(name string) (interface{}, bool) {
+			index, ok := info.fields[name]
+			if !ok {
+				return nil, false
+			}
+			return v.FieldByIndex(index).Interface(), true
+		}, nil
+	default:
+		return nil, fmt.Errorf("cannot bind named parameters from %T, it must be a struct or a map with string keys", arg)
+	}
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// expandValue returns the elements of slice values and value itself otherwise
+func expandValue(name string, value interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || v.Type().Implements(valuerType) {
+		return []interface{}{value}, nil
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []interface{}{value}, nil
+	}
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		return []interface{}{value}, nil
+	}
+	if v.Len() == 0 {
+		return nil, fmt.Errorf("cannot expand empty list for named parameter %s", name)
+	}
+
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	return values, nil
+}
@@ -0,0 +1,150 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+func TestNamed(t *testing.T) {
+	type account struct {
+		ID    int64
+		Email string   `db:"email_address"`
+		Tags  []string `db:"tags"`
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		arg      interface{}
+		expected string
+		args     []interface{}
+	}{
+		{
+			name:     "struct",
+			query:    "INSERT INTO accounts (id, email) VALUES (:id, :email_address)",
+			arg:      account{ID: 1, Email: "a@example.com"},
+			expected: "INSERT INTO accounts (id, email) VALUES ($1, $2)",
+			args:     []interface{}{int64(1), "a@example.com"},
+		},
+		{
+			name:     "pointer to struct with repeated name",
+			query:    "SELECT * FROM accounts WHERE id = :id OR parent_id = :id",
+			arg:      &account{ID: 7},
+			expected: "SELECT * FROM accounts WHERE id = $1 OR parent_id = $1",
+			args:     []interface{}{int64(7)},
+		},
+		{
+			name:     "map with slice expansion",
+			query:    "SELECT * FROM accounts WHERE id IN (:ids) AND name = :name",
+			arg:      map[string]interface{}{"ids": []int{1, 2, 3}, "name": "ann"},
+			expected: "SELECT * FROM accounts WHERE id IN ($1, $2, $3) AND name = $4",
+			args:     []interface{}{1, 2, 3, "ann"},
+		},
+		{
+			name:     "bytes and valuers are not expanded",
+			query:    "UPDATE accounts SET avatar = :avatar, tags = :tags",
+			arg:      map[string]interface{}{"avatar": []byte("png"), "tags": pq.StringArray{"a", "b"}},
+			expected: "UPDATE accounts SET avatar = $1, tags = $2",
+			args:     []interface{}{[]byte("png"), pq.StringArray{"a", "b"}},
+		},
+		{
+			name:     "casts, strings and comments are untouched",
+			query:    "SELECT ':nope', \":nope\", created_at::date -- :nope\nFROM t /* :nope */ WHERE id = :id",
+			arg:      map[string]int{"id": 1},
+			expected: "SELECT ':nope', \":nope\", created_at::date -- :nope\nFROM t /* :nope */ WHERE id = $1",
+			args:     []interface{}{1},
+		},
+		{
+			name:     "dollar quoted bodies are untouched",
+			query:    "SELECT $$ :nope $$, $fn$ a := :nope; $$ $fn$, :id",
+			arg:      map[string]int{"id": 1},
+			expected: "SELECT $$ :nope $$, $fn$ a := :nope; $$ $fn$, $1",
+			args:     []interface{}{1},
+		},
+		{
+			name:     "escape strings are untouched",
+			query:    `SELECT E'it\'s :nope', e'a'' :nope', name FROM t WHERE id = :id`,
+			arg:      map[string]int{"id": 1},
+			expected: `SELECT E'it\'s :nope', e'a'' :nope', name FROM t WHERE id = $1`,
+			args:     []interface{}{1},
+		},
+		{
+			name:     "array slices are untouched",
+			query:    "SELECT tags[lo:hi], tags[:3], tags[1:2] FROM t WHERE id = :id",
+			arg:      map[string]int{"id": 1},
+			expected: "SELECT tags[lo:hi], tags[:3], tags[1:2] FROM t WHERE id = $1",
+			args:     []interface{}{1},
+		},
+		{
+			name:     "parameters inside brackets",
+			query:    "SELECT ARRAY[:a, :b], tags[:idx] FROM t",
+			arg:      map[string]int{"a": 1, "b": 2, "idx": 3},
+			expected: "SELECT ARRAY[$1, $2], tags[$3] FROM t",
+			args:     []interface{}{1, 2, 3},
+		},
+		{
+			name:     "no parameters",
+			query:    "SELECT now()",
+			arg:      nil,
+			expected: "SELECT now()",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := Named(tt.query, tt.arg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, query)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+func TestNamedErrors(t *testing.T) {
+	_, _, err := Named("SELECT :missing", map[string]interface{}{})
+	assert.ErrorIs(t, err, ErrMissingParameter)
+
+	_, _, err = Named("SELECT * FROM t WHERE id IN (:ids)", map[string]interface{}{"ids": []int{}})
+	assert.ErrorContains(t, err, "empty list")
+
+	_, _, err = Named("SELECT :id", 42)
+	assert.Error(t, err)
+}
+
+func TestParseNamedQueryIsCached(t *testing.T) {
+	query := "SELECT :a, :b"
+	assert.Same(t, parseNamedQuery(query), parseNamedQuery(query))
+}
+
+func TestParseNamedQueryCacheIsBounded(t *testing.T) {
+	for i := 0; i <= maxNamedQueries; i++ {
+		parseNamedQuery(fmt.Sprintf("SELECT :a -- %d", i))
+	}
+
+	namedQueryCache.RLock()
+	defer namedQueryCache.RUnlock()
+	assert.Len(t, namedQueryCache.queries, maxNamedQueries)
+}
+
+func TestNamedExecContext(t *testing.T) {
+	var gotQuery string
+	var gotArgs []interface{}
+	fake := &sqltest.Driver{Exec: func(_ context.Context, query string, args []interface{}) (driver.Result, error) {
+		gotQuery, gotArgs = query, args
+		return driver.RowsAffected(1), nil
+	}}
+	db := newFakeDB(t, fake)
+
+	_, err := db.NamedExecContext(context.Background(), "DELETE FROM t WHERE id IN (:ids)", map[string]interface{}{"ids": []int64{4, 5}})
+	require.NoError(t, err)
+	assert.Equal(t, "DELETE FROM t WHERE id IN ($1, $2)", gotQuery)
+	require.Len(t, gotArgs, 2)
+	assert.Equal(t, int64(5), gotArgs[1])
+}
@@ -0,0 +1,250 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/knadh/koanf"
+	"github.com/lib/pq"
+	"github.com/zhughes3/elliot/pkg/log"
+	"github.com/zhughes3/elliot/pkg/secret"
+)
+
+const subscriptionBufferSize = 64
+
+// Notification is a message received on a LISTEN channel
+type Notification struct {
+	Channel string
+	Payload string
+	// PID is the process id of the server session that sent the notification
+	PID int
+}
+
+// ErrSubscriberClosed is returned by Subscribe and Unsubscribe after Close
+var ErrSubscriberClosed = errors.New("subscriber is closed")
+
+// Subscriber receives postgres notifications on a dedicated connection. The
+// connection is re-established after it drops and every subscribed channel
+// listened to again; notifications sent while disconnected are lost.
+type Subscriber interface {
+	// Subscribe listens on channel and returns a Go channel receiving its
+	// notifications. Each call returns a new Go channel. Notifications are
+	// dropped, and a warning logged, while a Go channel's buffer is full.
+	Subscribe(channel string) (<-chan Notification, error)
+	// Unsubscribe stops listening on channel and closes the Go channels returned for it
+	Unsubscribe(channel string) error
+	// Close closes the connection and every Go channel returned by Subscribe
+	Close() error
+}
+
+// listener is the part of pq.Listener used by subscriber
+type listener interface {
+	Listen(channel string) error
+	Unlisten(channel string) error
+	NotificationChannel() <-chan *pq.Notification
+	Close() error
+}
+
+type subscriber struct {
+	listener listener
+	logger   log.Logger
+	cleanup  func()
+	done     chan struct{}
+
+	// listenMu serialises Listen and Unlisten, which block until the listener
+	// connection answers. mu is never held while waiting for it, since the
+	// answer can be stuck behind notifications waiting to be dispatched.
+	listenMu sync.Mutex
+	mu       sync.Mutex
+	closed   bool
+	subs     map[string][]chan Notification
+}
+
+// NewSubscriber opens a Subscriber using the same configuration as NewDB.
+// Configurations setting DB_PASSWORD_SECRET need NewSubscriberWithKeyVault.
+func NewSubscriber(ctx context.Context, logger log.Logger, cfg *koanf.Koanf) (Subscriber, error) {
+	return NewSubscriberWithKeyVault(ctx, logger, cfg, nil)
+}
+
+// NewSubscriberWithKeyVault opens a Subscriber like NewSubscriber, reading the
+// password from vault when DB_PASSWORD_SECRET is configured, see NewDBWithKeyVault
+func NewSubscriberWithKeyVault(ctx context.Context, logger log.Logger, cfg *koanf.Koanf, vault secret.KeyVault) (Subscriber, error) {
+	dbCfg, err := parseDBConfiguration(logger, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("problem parsing db configuration: %w", err)
+	}
+
+	provider, err := credentialsProviderFor(dbCfg, vault)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPostgresSubscriber(ctx, logger, dbCfg, provider)
+}
+
+// NewPostgresSubscriber opens a Subscriber to a postgres server. Credentials
+// are read from provider once, reconnects keep using them. Reconnect attempts
+// back off between the configured initial and max connect backoff.
+func NewPostgresSubscriber(ctx context.Context, logger log.Logger, cfg dbConfig, provider CredentialsProvider) (Subscriber, error) {
+	credentials, err := provider.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("problem getting database credentials: %w", err)
+	}
+	if len(credentials.User) > 0 {
+		cfg.User = credentials.User
+	}
+	cfg.Password = credentials.Password
+
+	cfg, removeSSLMaterial, err := writeSSLMaterial(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	minReconnect, maxReconnect := cfg.Retry.Backoff.Initial, cfg.Retry.Backoff.Max
+	if minReconnect <= 0 {
+		minReconnect = defaultConnectInitialBackoff
+	}
+	if maxReconnect < minReconnect {
+		maxReconnect = minReconnect
+	}
+
+	l := pq.NewListener(newPostgresConnectionString(cfg), minReconnect, maxReconnect, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnected:
+			logger.Infof("notification listener connected to postgres server at %s", cfg.Host)
+		case pq.ListenerEventDisconnected:
+			logger.Warnf("notification listener lost its connection: %v", err)
+		case pq.ListenerEventReconnected:
+			logger.Infof("notification listener reconnected to postgres server at %s", cfg.Host)
+		case pq.ListenerEventConnectionAttemptFailed:
+			logger.Warnf("notification listener failed to connect: %v", err)
+		}
+	})
+
+	return newSubscriber(logger, l, removeSSLMaterial), nil
+}
+
+func newSubscriber(logger log.Logger, l listener, cleanup func()) *subscriber {
+	s := &subscriber{
+		listener: l,
+		logger:   logger,
+		cleanup:  cleanup,
+		done:     make(chan struct{}),
+		subs:     map[string][]chan Notification{},
+	}
+	go s.dispatch()
+	return s
+}
+
+func (s *subscriber) Subscribe(channel string) (<-chan Notification, error) {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	s.mu.Lock()
+	closed, listening := s.closed, len(s.subs[channel]) > 0
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrSubscriberClosed
+	}
+
+	if !listening {
+		if err := s.listener.Listen(channel); err != nil {
+			return nil, fmt.Errorf("problem listening on channel %s: %w", channel, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrSubscriberClosed
+	}
+	ch := make(chan Notification, subscriptionBufferSize)
+	s.subs[channel] = append(s.subs[channel], ch)
+	return ch, nil
+}
+
+func (s *subscriber) Unsubscribe(channel string) error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSubscriberClosed
+	}
+	subs, ok := s.subs[channel]
+	delete(s.subs, channel)
+	for _, ch := range subs {
+		close(ch)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if err := s.listener.Unlisten(channel); err != nil {
+		return fmt.Errorf("problem unlistening on channel %s: %w", channel, err)
+	}
+	return nil
+}
+
+func (s *subscriber) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	<-s.done
+
+	s.mu.Lock()
+	for channel, subs := range s.subs {
+		for _, ch := range subs {
+			close(ch)
+		}
+		delete(s.subs, channel)
+	}
+	s.mu.Unlock()
+
+	if s.cleanup != nil {
+		s.cleanup()
+	}
+	return err
+}
+
+// dispatch delivers notifications until the listener is closed. pq.Listener
+// sends nil after it reconnected and listened on every channel again.
+func (s *subscriber) dispatch() {
+	defer close(s.done)
+
+	for n := range s.listener.NotificationChannel() {
+		if n == nil {
+			s.logger.Infof("notification listener resumed, notifications sent while disconnected were lost")
+			continue
+		}
+
+		notification := Notification{Channel: n.Channel, Payload: n.Extra, PID: n.BePid}
+		s.mu.Lock()
+		for _, ch := range s.subs[n.Channel] {
+			select {
+			case ch <- notification:
+			default:
+				s.logger.Warnf("dropped notification on channel %s, subscriber is not keeping up", n.Channel)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Notify sends payload to the listeners of channel
+func (d DB) Notify(ctx context.Context, channel, payload string) error {
+	if _, err := d.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload); err != nil {
+		return fmt.Errorf("problem notifying channel %s: %w", channel, err)
+	}
+	return nil
+}
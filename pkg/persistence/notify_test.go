@@ -0,0 +1,142 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+// fakeListener records LISTEN/UNLISTEN calls and lets tests push notifications
+type fakeListener struct {
+	mu        sync.Mutex
+	listening map[string]bool
+	notify    chan *pq.Notification
+	closed    bool
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{listening: map[string]bool{}, notify: make(chan *pq.Notification)}
+}
+
+func (f *fakeListener) Listen(channel string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.listening[channel] {
+		return pq.ErrChannelAlreadyOpen
+	}
+	f.listening[channel] = true
+	return nil
+}
+
+func (f *fakeListener) Unlisten(channel string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.listening[channel] {
+		return pq.ErrChannelNotOpen
+	}
+	delete(f.listening, channel)
+	return nil
+}
+
+func (f *fakeListener) NotificationChannel() <-chan *pq.Notification { return f.notify }
+
+func (f *fakeListener) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	close(f.notify)
+	return nil
+}
+
+func (f *fakeListener) isListening(channel string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.listening[channel]
+}
+
+func receive(t *testing.T, ch <-chan Notification) Notification {
+	t.Helper()
+	select {
+	case n, ok := <-ch:
+		require.True(t, ok, "channel closed")
+		return n
+	case <-time.After(time.Second):
+		require.FailNow(t, "no notification received")
+		return Notification{}
+	}
+}
+
+func TestSubscriberDeliversNotifications(t *testing.T) {
+	l := newFakeListener()
+	s := newSubscriber(logtest.NewNopLogger(), l, nil)
+	defer s.Close()
+
+	first, err := s.Subscribe("jobs")
+	require.NoError(t, err)
+	second, err := s.Subscribe("jobs")
+	require.NoError(t, err)
+	other, err := s.Subscribe("other")
+	require.NoError(t, err)
+	assert.True(t, l.isListening("jobs"))
+
+	l.notify <- &pq.Notification{Channel: "jobs", Extra: "42", BePid: 7}
+	// pq.Listener sends nil after reconnecting
+	l.notify <- nil
+	l.notify <- &pq.Notification{Channel: "other", Extra: "x"}
+
+	assert.Equal(t, Notification{Channel: "jobs", Payload: "42", PID: 7}, receive(t, first))
+	assert.Equal(t, Notification{Channel: "jobs", Payload: "42", PID: 7}, receive(t, second))
+	assert.Equal(t, "x", receive(t, other).Payload)
+}
+
+func TestSubscriberUnsubscribe(t *testing.T) {
+	l := newFakeListener()
+	s := newSubscriber(logtest.NewNopLogger(), l, nil)
+
+	ch, err := s.Subscribe("jobs")
+	require.NoError(t, err)
+	require.NoError(t, s.Unsubscribe("jobs"))
+	assert.False(t, l.isListening("jobs"))
+	_, open := <-ch
+	assert.False(t, open)
+
+	require.NoError(t, s.Unsubscribe("never-subscribed"))
+
+	ch, err = s.Subscribe("jobs")
+	require.NoError(t, err)
+
+	cleaned := false
+	s.cleanup = func() { cleaned = true }
+	require.NoError(t, s.Close())
+	_, open = <-ch
+	assert.False(t, open)
+	assert.True(t, cleaned)
+
+	_, err = s.Subscribe("jobs")
+	assert.ErrorIs(t, err, ErrSubscriberClosed)
+	assert.ErrorIs(t, s.Unsubscribe("jobs"), ErrSubscriberClosed)
+}
+
+func TestNotify(t *testing.T) {
+	var gotQuery string
+	var gotArgs []interface{}
+	fake := &sqltest.Driver{Exec: func(_ context.Context, query string, args []interface{}) (driver.Result, error) {
+		gotQuery, gotArgs = query, args
+		return driver.RowsAffected(1), nil
+	}}
+	db := newFakeDB(t, fake)
+
+	require.NoError(t, db.Notify(context.Background(), "jobs", "42"))
+	assert.Equal(t, "SELECT pg_notify($1, $2)", gotQuery)
+	require.Len(t, gotArgs, 2)
+	assert.Equal(t, "jobs", gotArgs[0])
+	assert.Equal(t, "42", gotArgs[1])
+}
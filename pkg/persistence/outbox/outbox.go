@@ -0,0 +1,129 @@
+// Package outbox implements the transactional outbox pattern: events are
+// written to a Postgres table in the same transaction as the data they
+// describe, and a Relay publishes them afterwards, so an event is published
+// if and only if its transaction committed.
+//
+// Events with the same topic and key are published in the order their
+// transactions committed: Write holds a transaction lock on the topic and key,
+// so a later event cannot be written until the earlier one committed or rolled
+// back. Events with different topics or keys have no guaranteed order.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/zhughes3/elliot/pkg/persistence"
+)
+
+const defaultTable = "outbox"
+
+// Event is a message written to the outbox
+type Event struct {
+	ID    int64
+	Topic string
+	// Key identifies the entity the event is about, publishers may use it for partitioning
+	Key       string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// Publisher delivers events to a message broker. Events may be published more
+// than once, when the relay stops before recording them as sent.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// PublisherFunc adapts a function to a Publisher
+type PublisherFunc func(ctx context.Context, event Event) error
+
+// Publish calls f
+func (f PublisherFunc) Publish(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// Options configure an Outbox
+type Options struct {
+	// Table stores the events, defaults to outbox. It may be schema qualified.
+	Table string
+}
+
+// Outbox writes events in the caller's transaction
+type Outbox interface {
+	// CreateTable creates the outbox table and its index if they do not exist
+	CreateTable(ctx context.Context) error
+	// Write adds an event with payload encoded as JSON to the outbox within tx
+	// and returns its id. It is published once tx commits. Until then, other
+	// transactions writing an event with the same topic and key wait.
+	Write(ctx context.Context, tx *sql.Tx, topic, key string, payload interface{}) (int64, error)
+}
+
+type outbox struct {
+	db    persistence.DB
+	name  string
+	table string
+	index string
+}
+
+// New returns an Outbox storing events in db
+func New(db persistence.DB, opts Options) Outbox {
+	table := tableName(opts.Table)
+	return &outbox{
+		db:    db,
+		name:  table,
+		table: persistence.QuoteTable(table),
+		index: persistence.IndexName(table, "_unsent_idx"),
+	}
+}
+
+func tableName(table string) string {
+	if len(table) == 0 {
+		return defaultTable
+	}
+	return table
+}
+
+// lockName is the advisory lock serialising writers of events with topic and key
+func (o *outbox) lockName(topic, key string) string {
+	return fmt.Sprintf("outbox:%s:%q:%q", o.name, topic, key)
+}
+
+func (o *outbox) CreateTable(ctx context.Context) error {
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id         BIGSERIAL PRIMARY KEY,
+	topic      TEXT NOT NULL,
+	key        TEXT NOT NULL DEFAULT '',
+	payload    JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	sent_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (id) WHERE sent_at IS NULL`, o.table, o.index)
+
+	if _, err := o.db.ExecContext(ctx, create); err != nil {
+		return fmt.Errorf("problem creating outbox table: %w", err)
+	}
+	return nil
+}
+
+func (o *outbox) Write(ctx context.Context, tx *sql.Tx, topic, key string, payload interface{}) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("problem encoding event payload: %w", err)
+	}
+
+	// ids are assigned on insert but become visible on commit, so writers of
+	// the same topic and key are serialised to make id order match commit order
+	if err := persistence.LockTx(ctx, tx, o.lockName(topic, key)); err != nil {
+		return 0, err
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s (topic, key, payload) VALUES ($1, $2, $3) RETURNING id", o.table)
+	var id int64
+	if err := tx.QueryRowContext(ctx, insert, topic, key, string(data)).Scan(&id); err != nil {
+		return 0, fmt.Errorf("problem writing event to outbox: %w", persistence.ClassifyError(err))
+	}
+	return id, nil
+}
@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/persistence"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+func TestPublishInOrder(t *testing.T) {
+	events := []Event{{ID: 1, Topic: "users"}, {ID: 2, Topic: "users"}, {ID: 3, Topic: "users"}}
+
+	var published []int64
+	publisher := PublisherFunc(func(_ context.Context, event Event) error {
+		if event.ID == 2 {
+			return errors.New("broker unavailable")
+		}
+		published = append(published, event.ID)
+		return nil
+	})
+
+	sent, err := publishInOrder(context.Background(), publisher, events)
+	assert.ErrorContains(t, err, "problem publishing event 2 on topic users: broker unavailable")
+	assert.Equal(t, []int64{1}, sent)
+	assert.Equal(t, []int64{1}, published, "events after a failure must not be published")
+
+	sent, err = publishInOrder(context.Background(), PublisherFunc(func(context.Context, Event) error { return nil }), events)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, sent)
+}
+
+func TestNewRelayDefaults(t *testing.T) {
+	r := NewRelay(logtest.NewNopLogger(), persistence.DB{}, nil, RelayOptions{}).(*relay)
+
+	assert.Equal(t, `"outbox"`, r.table)
+	assert.Equal(t, defaultBatchSize, r.batchSize)
+	assert.Equal(t, defaultPollInterval, r.pollInterval)
+	assert.Equal(t, defaultRetention, r.retention)
+	assert.Equal(t, defaultCleanupInterval, r.cleanupInterval)
+	assert.Equal(t, persistence.LockKey("outbox:outbox"), r.lockID)
+}
+
+func TestRelayStopWithoutStart(t *testing.T) {
+	r := NewRelay(logtest.NewNopLogger(), persistence.DB{}, nil, RelayOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, r.Stop(ctx))
+}
+
+// outboxDB scripts an outbox table holding events, with the relay lock
+// available when locked is true
+func outboxDB(t *testing.T, locked bool, events ...Event) (*sqltest.Driver, persistence.DB) {
+	fake := &sqltest.Driver{
+		Query: func(query string, _ []interface{}) (driver.Rows, error) {
+			switch {
+			case strings.Contains(query, "pg_try_advisory_xact_lock"):
+				return sqltest.NewRows("locked", locked), nil
+			case strings.HasPrefix(query, "INSERT"):
+				return sqltest.NewRows("id", int64(42)), nil
+			case strings.HasPrefix(query, "SELECT id, topic"):
+				rows := &sqltest.Rows{ColumnNames: []string{"id", "topic", "key", "payload", "created_at"}}
+				for _, e := range events {
+					rows.Values = append(rows.Values, []driver.Value{e.ID, e.Topic, e.Key, []byte(e.Payload), e.CreatedAt})
+				}
+				return rows, nil
+			}
+			return &sqltest.Rows{}, nil
+		},
+		Exec: func(context.Context, string, []interface{}) (driver.Result, error) {
+			return driver.RowsAffected(3), nil
+		},
+	}
+	return fake, persistence.NewDBFromConn(fake.Open(t))
+}
+
+func TestWriteSerialisesWritersOfTheSameKey(t *testing.T) {
+	fake, db := outboxDB(t, true)
+	o := New(db, Options{Table: "events.outbox"})
+
+	err := db.WithTx(context.Background(), nil, func(tx *sql.Tx) error {
+		id, err := o.Write(context.Background(), tx, "users", "user-1", map[string]string{"email": "a@example.com"})
+		assert.Equal(t, int64(42), id)
+		return err
+	})
+	require.NoError(t, err)
+
+	statements := fake.Statements()
+	require.Len(t, statements, 4)
+	assert.Equal(t, "BEGIN", statements[0].Query)
+	assert.Equal(t, "SELECT pg_advisory_xact_lock($1)", statements[1].Query)
+	assert.Equal(t, []interface{}{persistence.LockKey(`outbox:events.outbox:"users":"user-1"`)}, statements[1].Args)
+	assert.Equal(t, `INSERT INTO "events"."outbox" (topic, key, payload) VALUES ($1, $2, $3) RETURNING id`, statements[2].Query)
+	assert.Equal(t, []interface{}{"users", "user-1", `{"email":"a@example.com"}`}, statements[2].Args)
+	assert.Equal(t, "COMMIT", statements[3].Query)
+}
+
+func TestRelayBatchMarksPublishedEventsSent(t *testing.T) {
+	events := []Event{
+		{ID: 1, Topic: "users", Key: "a", Payload: json.RawMessage(`{}`)},
+		{ID: 2, Topic: "users", Key: "b", Payload: json.RawMessage(`{}`)},
+		{ID: 3, Topic: "users", Key: "a", Payload: json.RawMessage(`{}`)},
+	}
+	fake, db := outboxDB(t, true, events...)
+
+	var published []int64
+	publisher := PublisherFunc(func(_ context.Context, event Event) error {
+		if event.ID == 3 {
+			return errors.New("broker unavailable")
+		}
+		published = append(published, event.ID)
+		return nil
+	})
+	r := NewRelay(logtest.NewNopLogger(), db, publisher, RelayOptions{BatchSize: 10}).(*relay)
+
+	sent, err := r.relayBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []int64{1, 2}, published)
+
+	statements := fake.Statements()
+	require.Len(t, statements, 5)
+	assert.Equal(t, []interface{}{r.lockID}, statements[1].Args)
+	assert.Contains(t, statements[2].Query, "WHERE sent_at IS NULL ORDER BY id LIMIT $1")
+	assert.Equal(t, []interface{}{int64(10)}, statements[2].Args)
+	assert.Equal(t, `UPDATE "outbox" SET sent_at = now() WHERE id = ANY($1)`, statements[3].Query)
+	assert.Equal(t, []interface{}{"{1,2}"}, statements[3].Args, "only published events are marked sent")
+	assert.Equal(t, "COMMIT", statements[4].Query)
+}
+
+func TestRelayBatchWaitsForTheLock(t *testing.T) {
+	fake, db := outboxDB(t, false, Event{ID: 1})
+	publisher := PublisherFunc(func(context.Context, Event) error {
+		t.Fatal("published without holding the relay lock")
+		return nil
+	})
+	r := NewRelay(logtest.NewNopLogger(), db, publisher, RelayOptions{}).(*relay)
+
+	sent, err := r.relayBatch(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+	assert.Equal(t, []string{"BEGIN", "SELECT pg_try_advisory_xact_lock($1)", "COMMIT"}, fake.Queries())
+}
+
+func TestCleanupDeletesOldSentEvents(t *testing.T) {
+	fake, db := outboxDB(t, true)
+	r := NewRelay(logtest.NewNopLogger(), db, nil, RelayOptions{Retention: time.Hour}).(*relay)
+
+	require.NoError(t, r.cleanup(context.Background()))
+	statements := fake.Statements()
+	require.Len(t, statements, 1)
+	assert.Equal(t, `DELETE FROM "outbox" WHERE sent_at < now() - $1::float8 * INTERVAL '1 millisecond'`, statements[0].Query)
+	assert.Equal(t, []interface{}{int64(time.Hour / time.Millisecond)}, statements[0].Args)
+}
@@ -0,0 +1,247 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/zhughes3/elliot/pkg/log"
+	"github.com/zhughes3/elliot/pkg/persistence"
+)
+
+const (
+	defaultBatchSize       = 100
+	defaultPollInterval    = time.Second
+	defaultRetention       = 7 * 24 * time.Hour
+	defaultCleanupInterval = time.Hour
+)
+
+// RelayOptions configure a Relay
+type RelayOptions struct {
+	// Table stores the events, defaults to outbox. It may be schema qualified.
+	Table string
+	// BatchSize is the number of events published per transaction, defaults to 100
+	BatchSize int
+	// PollInterval is how long the relay waits when there is nothing to publish, defaults to 1s
+	PollInterval time.Duration
+	// Retention is how long sent events are kept, defaults to 7 days
+	Retention time.Duration
+	// CleanupInterval is how often sent events past Retention are deleted, defaults to 1h
+	CleanupInterval time.Duration
+}
+
+// Relay publishes the events written to the outbox in id order, which for
+// events of the same topic and key is the order they committed in. Relays of
+// several replicas take turns, so only one publishes at a time. Events are
+// published at least once: an event whose publication or bookkeeping failed is
+// published again, and the events after it wait for it to keep the order.
+type Relay interface {
+	// Start starts publishing in the background
+	Start()
+	// Stop stops publishing, waiting for the current batch to finish. If ctx is
+	// done first, the batch is cancelled and ctx's error returned.
+	Stop(ctx context.Context) error
+}
+
+type relay struct {
+	logger    log.Logger
+	db        persistence.DB
+	publisher Publisher
+	table     string
+	// lockID depends on the table so that relays of different outboxes do not block each other
+	lockID          int64
+	batchSize       int
+	pollInterval    time.Duration
+	retention       time.Duration
+	cleanupInterval time.Duration
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+	ctx       context.Context
+	abort     context.CancelFunc
+}
+
+// NewRelay returns a Relay publishing the events in db through publisher
+func NewRelay(logger log.Logger, db persistence.DB, publisher Publisher, opts RelayOptions) Relay {
+	table := tableName(opts.Table)
+	r := &relay{
+		logger:          logger,
+		db:              db,
+		publisher:       publisher,
+		table:           persistence.QuoteTable(table),
+		lockID:          persistence.LockKey("outbox:" + table),
+		batchSize:       opts.BatchSize,
+		pollInterval:    opts.PollInterval,
+		retention:       opts.Retention,
+		cleanupInterval: opts.CleanupInterval,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = defaultBatchSize
+	}
+	if r.pollInterval <= 0 {
+		r.pollInterval = defaultPollInterval
+	}
+	if r.retention <= 0 {
+		r.retention = defaultRetention
+	}
+	if r.cleanupInterval <= 0 {
+		r.cleanupInterval = defaultCleanupInterval
+	}
+	r.ctx, r.abort = context.WithCancel(context.Background())
+	return r
+}
+
+func (r *relay) Start() {
+	r.startOnce.Do(func() { go r.run() })
+}
+
+func (r *relay) Stop(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	// a relay that was never started has nothing to wait for
+	r.startOnce.Do(func() { close(r.done) })
+
+	select {
+	case <-r.done:
+		r.abort()
+		return nil
+	case <-ctx.Done():
+		r.logger.Warnf("stopping outbox relay timed out, cancelling the current batch")
+		r.abort()
+		<-r.done
+		return ctx.Err()
+	}
+}
+
+func (r *relay) run() {
+	defer close(r.done)
+
+	var lastCleanup time.Time
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		if time.Since(lastCleanup) >= r.cleanupInterval {
+			lastCleanup = time.Now()
+			if err := r.cleanup(r.ctx); err != nil {
+				r.logger.Warnf("problem cleaning up outbox: %v", err)
+			}
+		}
+
+		published, err := r.relayBatch(r.ctx)
+		if err != nil {
+			r.logger.Warnf("problem relaying outbox events: %v", err)
+		}
+		if err == nil && published == r.batchSize {
+			// there is probably more to publish
+			continue
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// relayBatch publishes the oldest unsent events and records them as sent, all
+// in one transaction holding the relay lock. It returns the number of events
+// recorded as sent.
+func (r *relay) relayBatch(ctx context.Context) (int, error) {
+	var published int
+	err := r.db.WithTx(ctx, &persistence.TxOptions{MaxAttempts: 1}, func(tx *sql.Tx) error {
+		published = 0
+
+		var locked bool
+		if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", r.lockID).Scan(&locked); err != nil {
+			return fmt.Errorf("problem acquiring outbox relay lock: %w", err)
+		}
+		if !locked {
+			// another relay is publishing
+			return nil
+		}
+
+		events, err := r.unsent(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		sent, publishErr := publishInOrder(ctx, r.publisher, events)
+		if len(sent) > 0 {
+			update := fmt.Sprintf("UPDATE %s SET sent_at = now() WHERE id = ANY($1)", r.table)
+			if _, err := tx.ExecContext(ctx, update, pq.Array(sent)); err != nil {
+				return fmt.Errorf("problem marking outbox events as sent: %w", err)
+			}
+			published = len(sent)
+		}
+		if publishErr != nil {
+			// commit the events that were published and retry the rest later
+			r.logger.Warnf("problem publishing outbox event: %v", publishErr)
+		}
+		return nil
+	})
+	return published, err
+}
+
+func (r *relay) unsent(ctx context.Context, tx *sql.Tx) ([]Event, error) {
+	query := fmt.Sprintf(`SELECT id, topic, key, payload, created_at FROM %s
+WHERE sent_at IS NULL ORDER BY id LIMIT $1`, r.table)
+
+	rows, err := tx.QueryContext(ctx, query, r.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.Topic, &event.Key, &payload, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("problem reading outbox events: %w", err)
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("problem reading outbox events: %w", err)
+	}
+	return events, nil
+}
+
+// publishInOrder publishes events one after the other, stopping at the first
+// failure so that no event overtakes an earlier one. It returns the ids of
+// the events published.
+func publishInOrder(ctx context.Context, publisher Publisher, events []Event) ([]int64, error) {
+	sent := make([]int64, 0, len(events))
+	for _, event := range events {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return sent, fmt.Errorf("problem publishing event %d on topic %s: %w", event.ID, event.Topic, err)
+		}
+		sent = append(sent, event.ID)
+	}
+	return sent, nil
+}
+
+// cleanup deletes the events sent longer than the retention ago
+func (r *relay) cleanup(ctx context.Context) error {
+	remove := fmt.Sprintf("DELETE FROM %s WHERE sent_at < now() - $1::float8 * INTERVAL '1 millisecond'", r.table)
+	result, err := r.db.ExecContext(ctx, remove, r.retention.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if removed, err := result.RowsAffected(); err == nil && removed > 0 {
+		r.logger.Infof("removed %d sent events from outbox", removed)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package persistence
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultMaxOpenConns    = 10
+	defaultMaxIdleConns    = 5
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+)
+
+// poolConfig holds the sql.DB connection pool settings. Zero values mean the
+// same as in database/sql: no limit for MaxOpenConns and the lifetimes, and no
+// idle connections kept for MaxIdleConns.
+type poolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// parsePoolConfiguration reads the connection pool settings from configuration
+func parsePoolConfiguration(r *configReader) poolConfig {
+	pool := poolConfig{
+		MaxOpenConns:    r.nonNegativeInt("DB_MAX_OPEN_CONNS", defaultMaxOpenConns),
+		MaxIdleConns:    r.nonNegativeInt("DB_MAX_IDLE_CONNS", defaultMaxIdleConns),
+		ConnMaxLifetime: r.nonNegativeDuration("DB_CONN_MAX_LIFETIME", defaultConnMaxLifetime),
+		ConnMaxIdleTime: r.nonNegativeDuration("DB_CONN_MAX_IDLE_TIME", defaultConnMaxIdleTime),
+	}
+	if pool.MaxOpenConns > 0 && pool.MaxIdleConns > pool.MaxOpenConns {
+		r.addProblem(fmt.Errorf("%w: DB_MAX_IDLE_CONNS (%d) cannot exceed DB_MAX_OPEN_CONNS (%d)", ErrInvalidConfiguration, pool.MaxIdleConns, pool.MaxOpenConns))
+	}
+	return pool
+}
+
+// applyPoolConfig configures the connection pool of db
+func applyPoolConfig(db *sql.DB, cfg poolConfig) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+}
@@ -0,0 +1,48 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+)
+
+func TestParsePoolConfiguration(t *testing.T) {
+	r := newConfigReader(logtest.NewNopLogger(), newTestConfig(t, map[string]interface{}{}))
+	pool := parsePoolConfiguration(r)
+	require.NoError(t, r.err())
+	assert.Equal(t, poolConfig{
+		MaxOpenConns:    defaultMaxOpenConns,
+		MaxIdleConns:    defaultMaxIdleConns,
+		ConnMaxLifetime: defaultConnMaxLifetime,
+		ConnMaxIdleTime: defaultConnMaxIdleTime,
+	}, pool)
+
+	r = newConfigReader(logtest.NewNopLogger(), newTestConfig(t, map[string]interface{}{
+		"DB_MAX_OPEN_CONNS":     50,
+		"DB_MAX_IDLE_CONNS":     "10",
+		"DB_CONN_MAX_LIFETIME":  "1h",
+		"DB_CONN_MAX_IDLE_TIME": "0s",
+	}))
+	pool = parsePoolConfiguration(r)
+	require.NoError(t, r.err())
+	assert.Equal(t, poolConfig{MaxOpenConns: 50, MaxIdleConns: 10, ConnMaxLifetime: time.Hour}, pool)
+}
+
+func TestParsePoolConfigurationInvalid(t *testing.T) {
+	tcs := []map[string]interface{}{
+		{"DB_MAX_OPEN_CONNS": "lots"},
+		{"DB_MAX_OPEN_CONNS": -1},
+		{"DB_MAX_OPEN_CONNS": 5, "DB_MAX_IDLE_CONNS": 10},
+		{"DB_CONN_MAX_LIFETIME": "forever"},
+		{"DB_CONN_MAX_IDLE_TIME": "-1m"},
+	}
+
+	for _, tc := range tcs {
+		r := newConfigReader(logtest.NewNopLogger(), newTestConfig(t, tc))
+		parsePoolConfiguration(r)
+		assert.ErrorIs(t, r.err(), ErrInvalidConfiguration, "%v should be rejected", tc)
+	}
+}
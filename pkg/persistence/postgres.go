@@ -1,31 +1,207 @@
 package persistence
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
 	"github.com/zhughes3/elliot/pkg/log"
 )
 
 // NewPostgresDB attempts to open a db handler to a postgres server
 func NewPostgresDB(logger log.Logger, cfg dbConfig) (DB, error) {
-	conn := newPostgresConnectionString(cfg)
+	return NewPostgresDBContext(context.Background(), logger, cfg)
+}
+
+// NewPostgresDBContext attempts to open a db handler to a postgres server,
+// giving up when ctx is done
+func NewPostgresDBContext(ctx context.Context, logger log.Logger, cfg dbConfig) (DB, error) {
+	return NewPostgresDBWithCredentials(ctx, logger, cfg, NewStaticCredentialsProvider(cfg.User, cfg.Password))
+}
+
+// NewPostgresDBWithCredentials attempts to open a db handler to a postgres
+// server, authenticating new connections with credentials from provider
+func NewPostgresDBWithCredentials(ctx context.Context, logger log.Logger, cfg dbConfig, provider CredentialsProvider) (DB, error) {
+	cfg, removeSSLMaterial, err := writeSSLMaterial(cfg)
+	if err != nil {
+		return DB{}, err
+	}
 
-	db, err := sql.Open("postgres", conn)
+	primaryConnector, err := newConnector(ctx, logger, cfg, provider)
 	if err != nil {
-		return DB{}, fmt.Errorf("problem opening Postgres connection: %v", err)
+		removeSSLMaterial()
+		return DB{}, fmt.Errorf("problem getting database credentials: %w", err)
 	}
 
-	err = db.Ping()
+	db := sql.OpenDB(primaryConnector)
+	applyPoolConfig(db, cfg.Pool)
+
+	err = pingWithRetry(ctx, logger, db, cfg.Retry)
 	if err != nil {
-		return DB{}, fmt.Errorf("problem verifying Postgres connection: %v", err)
+		_ = db.Close()
+		removeSSLMaterial()
+		return DB{}, fmt.Errorf("problem verifying Postgres connection: %w", err)
+	}
+
+	connectors := []*connector{primaryConnector}
+	replicas := make([]*replica, 0, len(cfg.Replicas))
+	for _, host := range cfg.Replicas {
+		replicaCfg := cfg
+		replicaCfg.Host, replicaCfg.Port = host.Host, host.Port
+		replicaConnector, err := newConnector(ctx, logger, replicaCfg, provider)
+		if err != nil {
+			for _, r := range replicas {
+				_ = r.db.Close()
+			}
+			_ = db.Close()
+			removeSSLMaterial()
+			return DB{}, fmt.Errorf("problem getting database credentials: %w", err)
+		}
+
+		replicaDB := sql.OpenDB(replicaConnector)
+		applyPoolConfig(replicaDB, cfg.Pool)
+		connectors = append(connectors, replicaConnector)
+		replicas = append(replicas, &replica{host: net.JoinHostPort(host.Host, host.Port), db: replicaDB})
+	}
+
+	stopRefresh := func() {}
+	if cfg.CredentialsRefreshInterval > 0 {
+		var refreshCtx context.Context
+		refreshCtx, stopRefresh = context.WithCancel(context.Background())
+		for _, c := range connectors {
+			go c.refreshEvery(refreshCtx, cfg.CredentialsRefreshInterval)
+		}
 	}
 
 	logger.Infof("connected to postgres server at %s", cfg.Host)
-	return NewDBFromConn(db), nil
+	d := DB{
+		DB:      db,
+		tracker: newTracker(),
+		cleanup: func() {
+			stopRefresh()
+			removeSSLMaterial()
+		},
+		logger:             logger,
+		slowQueryThreshold: cfg.SlowQueryThreshold,
+	}
+	if len(replicas) > 0 {
+		// unreachable replicas are not fatal, reads go to the primary until they recover
+		d = d.withReplicas(replicas, cfg.ReplicaCheckInterval)
+	}
+	return d, nil
 }
 
+// newPostgresConnectionString builds a postgres URL from cfg, escaping every
+// component so that credentials and names may contain reserved characters
 func newPostgresConnectionString(cfg dbConfig) string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name, "disable")
+	sslMode := cfg.SSLMode
+	if len(sslMode) == 0 {
+		sslMode = sslModeDisable
+	}
+
+	params := url.Values{}
+	for k, v := range cfg.Params {
+		params.Set(k, v)
+	}
+	params.Set("sslmode", sslMode)
+	if len(cfg.SSLRootCert) > 0 {
+		params.Set("sslrootcert", cfg.SSLRootCert)
+	}
+	if len(cfg.SSLCert) > 0 {
+		params.Set("sslcert", cfg.SSLCert)
+	}
+	if len(cfg.SSLKey) > 0 {
+		params.Set("sslkey", cfg.SSLKey)
+	}
+	if len(cfg.ApplicationName) > 0 {
+		params.Set("application_name", cfg.ApplicationName)
+	}
+	if cfg.ConnectTimeout > 0 {
+		// lib/pq only accepts whole seconds, round up so a short timeout is not disabled
+		params.Set("connect_timeout", strconv.Itoa(int((cfg.ConnectTimeout+time.Second-1)/time.Second)))
+	}
+	if len(cfg.SearchPath) > 0 {
+		params.Set("search_path", cfg.SearchPath)
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		Host:     cfg.Host,
+		Path:     "/" + cfg.Name,
+		RawPath:  "/" + url.PathEscape(cfg.Name),
+		RawQuery: params.Encode(),
+	}
+	switch {
+	case len(cfg.Port) > 0:
+		u.Host = net.JoinHostPort(cfg.Host, cfg.Port)
+	case strings.Contains(cfg.Host, ":"):
+		// an IPv6 literal must be bracketed even without a port
+		u.Host = "[" + cfg.Host + "]"
+	}
+	if len(cfg.User) > 0 || len(cfg.Password) > 0 {
+		u.User = url.UserPassword(cfg.User, cfg.Password)
+	}
+
+	return u.String()
+}
+
+// parseDatabaseURL creates a dbConfig from a postgres URL such as the one held in DATABASE_URL
+func parseDatabaseURL(raw string) (dbConfig, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url.Error repeats the whole URL, which would leak the password into logs
+		return dbConfig{}, fmt.Errorf("%w: DATABASE_URL is not a valid URL", ErrInvalidConfiguration)
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return dbConfig{}, newInvalidConfigurationError("DATABASE_URL scheme", u.Scheme)
+	}
+
+	cfg := dbConfig{
+		Host: u.Hostname(),
+		Port: u.Port(),
+		Name: strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		cfg.User = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+
+	for k, vs := range u.Query() {
+		if len(vs) == 0 {
+			continue
+		}
+		v := vs[0]
+		switch k {
+		case "sslmode":
+			cfg.SSLMode = v
+		case "sslrootcert":
+			cfg.SSLRootCert = v
+		case "sslcert":
+			cfg.SSLCert = v
+		case "sslkey":
+			cfg.SSLKey = v
+		case "application_name":
+			cfg.ApplicationName = v
+		case "search_path":
+			cfg.SearchPath = v
+		case "connect_timeout":
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds < 0 {
+				return dbConfig{}, newInvalidConfigurationError("connect_timeout in DATABASE_URL", v)
+			}
+			cfg.ConnectTimeout = time.Duration(seconds) * time.Second
+		default:
+			if cfg.Params == nil {
+				cfg.Params = map[string]string{}
+			}
+			cfg.Params[k] = v
+		}
+	}
+
+	return cfg, nil
 }
@@ -0,0 +1,162 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+const defaultSlowQueryThreshold = 500 * time.Millisecond
+
+// WithQueryLogging returns a copy of d whose ExecContext, QueryContext and
+// QueryRowContext log every statement at debug, and at warn when it takes
+// longer than slowQueryThreshold. A zero threshold disables slow query
+// warnings. Argument values are never logged, only their count.
+func (d DB) WithQueryLogging(logger log.Logger, slowQueryThreshold time.Duration) DB {
+	d.logger = logger
+	d.slowQueryThreshold = slowQueryThreshold
+	return d
+}
+
+// ExecContext executes a statement that returns no rows. Errors are classified with ClassifyError.
+func (d DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done, err := d.begin(ctx, "exec: "+query, true)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	start := time.Now()
+	result, err := d.DB.ExecContext(ctx, query, args...)
+
+	rows := int64(-1)
+	if err == nil {
+		if affected, affectedErr := result.RowsAffected(); affectedErr == nil {
+			rows = affected
+		}
+	}
+	d.logQuery("persistence.ExecContext", query, len(args), rows, time.Since(start), err)
+
+	return result, ClassifyError(err)
+}
+
+// QueryContext executes a statement that returns rows on the primary. The
+// logged duration is the time until the first row is available. Errors are
+// classified with ClassifyError. Shutdown tracking ends when QueryContext
+// returns, so iterating the rows is not tracked; Shutdown only waits for their
+// connection while closing the pool.
+func (d DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return d.query(ctx, "persistence.QueryContext", false, query, args)
+}
+
+// ReadQueryContext runs a statement that only reads like QueryContext, but on a
+// healthy read replica when there is one, and on the primary if the replica's
+// connection fails. Use it only for statements that tolerate replication lag.
+func (d DB) ReadQueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return d.query(ctx, "persistence.ReadQueryContext", true, query, args)
+}
+
+// QueryRowContext executes a statement that returns at most one row on the
+// primary. Once Shutdown was called, Scan on the returned row fails with
+// context.Canceled since sql.Row cannot carry ErrShutdown. As with
+// QueryContext, the Scan itself is not tracked.
+func (d DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return d.queryRow(ctx, "persistence.QueryRowContext", false, query, args)
+}
+
+// ReadQueryRowContext runs a statement that only reads like QueryRowContext,
+// but on a read replica like ReadQueryContext
+func (d DB) ReadQueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return d.queryRow(ctx, "persistence.ReadQueryRowContext", true, query, args)
+}
+
+// query runs a statement returning rows, on a replica when readOnly is true
+func (d DB) query(ctx context.Context, call string, readOnly bool, query string, args []interface{}) (*sql.Rows, error) {
+	_, done, err := d.begin(ctx, "query: "+query, false)
+	if err != nil {
+		return nil, err
+	}
+	// *sql.Rows gives no hook for Close, so only running the query is tracked
+	defer done()
+
+	db, replica := d.reader(readOnly)
+	start := time.Now()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if replica != nil && isFailoverError(ctx, err) {
+		d.replicas.markUnhealthy(replica, err)
+		rows, err = d.DB.QueryContext(ctx, query, args...)
+	}
+	d.logQuery(call, query, len(args), -1, time.Since(start), err)
+
+	return rows, ClassifyError(err)
+}
+
+// queryRow runs a statement returning at most one row, on a replica when readOnly is true
+func (d DB) queryRow(ctx context.Context, call string, readOnly bool, query string, args []interface{}) *sql.Row {
+	_, done, err := d.begin(ctx, "query: "+query, false)
+	if err != nil {
+		// sql.Row cannot be built with an error, so use a context that is already
+		// cancelled to make database/sql refuse the query
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		return d.DB.QueryRowContext(cancelled, query, args...)
+	}
+	defer done()
+
+	db, replica := d.reader(readOnly)
+	start := time.Now()
+	row := db.QueryRowContext(ctx, query, args...)
+	if replica != nil && isFailoverError(ctx, row.Err()) {
+		d.replicas.markUnhealthy(replica, row.Err())
+		row = d.DB.QueryRowContext(ctx, query, args...)
+	}
+	d.logQuery(call, query, len(args), -1, time.Since(start), row.Err())
+
+	return row
+}
+
+// logQuery writes a statement summary. rows is -1 when the number of rows is not known.
+func (d DB) logQuery(call, query string, args int, rows int64, dur time.Duration, err error) {
+	if d.logger == nil {
+		return
+	}
+
+	slow := d.slowQueryThreshold > 0 && dur >= d.slowQueryThreshold
+	logger := d.logger
+	if fl, ok := logger.(log.FieldLogger); ok {
+		fields := log.Fields{
+			log.CallKey:      call,
+			log.DurationKey:  dur,
+			log.StatementKey: query,
+			log.ArgCountKey:  args,
+		}
+		if rows >= 0 {
+			fields[log.RowsKey] = rows
+		}
+		if err != nil {
+			fields[log.ErrorKey] = err
+		}
+		logger = fl.WithFields(fields)
+
+		switch {
+		case slow:
+			logger.Warnf("slow query took longer than %s", d.slowQueryThreshold)
+		case err != nil:
+			logger.Debug("query failed")
+		default:
+			logger.Debug(log.CalledMessage)
+		}
+		return
+	}
+
+	switch {
+	case slow:
+		logger.Warnf("slow query took %s (threshold %s), %d args redacted: %s", dur, d.slowQueryThreshold, args, query)
+	case err != nil:
+		logger.Debugf("query failed after %s, %d args redacted: %s: %v", dur, args, query, err)
+	default:
+		logger.Debugf("query took %s, %d args redacted, %d rows: %s", dur, args, rows, query)
+	}
+}
@@ -0,0 +1,65 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+func TestExecContextLogsStatement(t *testing.T) {
+	fake := &sqltest.Driver{Exec: func(context.Context, string, []interface{}) (driver.Result, error) {
+		return driver.RowsAffected(3), nil
+	}}
+	logger := logtest.NewRecorder()
+	db := newFakeDB(t, fake).WithQueryLogging(logger, time.Hour)
+
+	_, err := db.ExecContext(context.Background(), "UPDATE users SET name = $1 WHERE email = $2", "secret name", "someone@example.com")
+	require.NoError(t, err)
+
+	entries := logger.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "debug", entries[0].Level)
+	assert.Equal(t, "persistence.ExecContext", entries[0].Fields[log.CallKey])
+	assert.Equal(t, 2, entries[0].Fields[log.ArgCountKey])
+	assert.Equal(t, int64(3), entries[0].Fields[log.RowsKey])
+	assert.IsType(t, time.Duration(0), entries[0].Fields[log.DurationKey])
+	for _, v := range entries[0].Fields {
+		assert.NotEqual(t, "secret name", v, "argument values must be redacted")
+	}
+}
+
+func TestQueryContextWarnsOnSlowQueries(t *testing.T) {
+	fake := &sqltest.Driver{Query: func(string, []interface{}) (driver.Rows, error) {
+		time.Sleep(5 * time.Millisecond)
+		return &sqltest.Rows{}, nil
+	}}
+	logger := logtest.NewRecorder()
+	db := newFakeDB(t, fake).WithQueryLogging(logger, time.Millisecond)
+
+	rows, err := db.QueryContext(context.Background(), "SELECT pg_sleep($1)", 1)
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+
+	entries := logger.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "warn", entries[0].Level)
+	assert.Equal(t, "SELECT pg_sleep($1)", entries[0].Fields[log.StatementKey])
+}
+
+func TestExecContextClassifiesErrors(t *testing.T) {
+	fake := &sqltest.Driver{Exec: func(context.Context, string, []interface{}) (driver.Result, error) {
+		return nil, &pq.Error{Code: "23505", Constraint: "users_email_key"}
+	}}
+	db := newFakeDB(t, fake)
+
+	_, err := db.ExecContext(context.Background(), "INSERT INTO users (email) VALUES ($1)", "someone@example.com")
+	assert.ErrorIs(t, err, ErrUniqueViolation)
+}
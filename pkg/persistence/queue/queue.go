@@ -0,0 +1,300 @@
+// Package queue is a job queue stored in a Postgres table. Jobs are dequeued
+// with FOR UPDATE SKIP LOCKED, so any number of workers can share a queue
+// without handing out the same job twice.
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zhughes3/elliot/pkg/log"
+	"github.com/zhughes3/elliot/pkg/persistence"
+)
+
+const (
+	defaultTable             = "jobs"
+	defaultMaxAttempts       = 5
+	defaultVisibilityTimeout = 5 * time.Minute
+	defaultInitialBackoff    = 10 * time.Second
+	defaultMaxBackoff        = time.Hour
+)
+
+// ErrJobLost is returned when completing or failing a job that was handed to
+// another worker after its visibility timeout expired
+var ErrJobLost = errors.New("job is no longer held by this worker")
+
+// State is the lifecycle state of a job
+type State string
+
+const (
+	// StatePending jobs wait to run at RunAt
+	StatePending State = "pending"
+	// StateRunning jobs are held by a worker until LockedUntil
+	StateRunning State = "running"
+	// StateDone jobs completed successfully
+	StateDone State = "done"
+	// StateDead jobs failed MaxAttempts times and are not retried
+	StateDead State = "dead"
+)
+
+// Options configure a Queue
+type Options struct {
+	// Table stores the jobs, defaults to jobs. It may be schema qualified.
+	Table string
+	// MaxAttempts is the default number of attempts before a job is dead, defaults to 5
+	MaxAttempts int
+	// VisibilityTimeout is how long a dequeued job is held before another
+	// worker may take it over, defaults to 5m
+	VisibilityTimeout time.Duration
+	// InitialBackoff is the delay before the first retry, doubling for every
+	// following retry up to MaxBackoff. Default to 10s and 1h.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// EnqueueOptions configure a single job
+type EnqueueOptions struct {
+	// Priority orders the jobs ready to run, higher first
+	Priority int
+	// RunAt delays the job, the zero value runs it right away
+	RunAt time.Time
+	// MaxAttempts overrides Options.MaxAttempts when positive
+	MaxAttempts int
+}
+
+// Job is a unit of work dequeued from a Queue
+type Job struct {
+	ID          int64
+	Queue       string
+	Payload     json.RawMessage
+	Priority    int
+	RunAt       time.Time
+	Attempts    int
+	MaxAttempts int
+	// LockedUntil is when the visibility timeout of the current attempt
+	// expires, by the local clock
+	LockedUntil time.Time
+	CreatedAt   time.Time
+}
+
+// Queue stores and hands out jobs
+type Queue interface {
+	// CreateTable creates the jobs table and its index if they do not exist
+	CreateTable(ctx context.Context) error
+	// Enqueue adds a job to queue with payload encoded as JSON and returns its id.
+	// opts may be nil for the defaults.
+	Enqueue(ctx context.Context, queue string, payload interface{}, opts *EnqueueOptions) (int64, error)
+	// Dequeue takes the next job ready to run from queue, highest priority
+	// first. found is false when no job is ready.
+	Dequeue(ctx context.Context, queue string) (job Job, found bool, err error)
+	// Complete marks a dequeued job as done
+	Complete(ctx context.Context, job Job) error
+	// Fail records why a dequeued job failed and schedules a retry with
+	// backoff, or marks it dead once it used up its attempts. cause may be nil.
+	Fail(ctx context.Context, job Job, cause error) error
+	// Release returns a dequeued job to the queue to run again right away,
+	// without counting the attempt, such as when its worker is stopped
+	Release(ctx context.Context, job Job) error
+}
+
+type queue struct {
+	logger            log.Logger
+	db                persistence.DB
+	table             string
+	index             string
+	maxAttempts       int
+	visibilityTimeout time.Duration
+	initialBackoff    time.Duration
+	maxBackoff        time.Duration
+}
+
+// New returns a Queue storing jobs in db
+func New(logger log.Logger, db persistence.DB, opts Options) Queue {
+	table := opts.Table
+	if len(table) == 0 {
+		table = defaultTable
+	}
+
+	q := &queue{
+		logger:            logger,
+		db:                db,
+		table:             persistence.QuoteTable(table),
+		index:             persistence.IndexName(table, "_dequeue_idx"),
+		maxAttempts:       opts.MaxAttempts,
+		visibilityTimeout: opts.VisibilityTimeout,
+		initialBackoff:    opts.InitialBackoff,
+		maxBackoff:        opts.MaxBackoff,
+	}
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = defaultMaxAttempts
+	}
+	if q.visibilityTimeout <= 0 {
+		q.visibilityTimeout = defaultVisibilityTimeout
+	}
+	if q.initialBackoff <= 0 {
+		q.initialBackoff = defaultInitialBackoff
+	}
+	if q.maxBackoff < q.initialBackoff {
+		q.maxBackoff = defaultMaxBackoff
+	}
+	return q
+}
+
+func (q *queue) CreateTable(ctx context.Context) error {
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id           BIGSERIAL PRIMARY KEY,
+	queue        TEXT NOT NULL,
+	payload      JSONB NOT NULL,
+	priority     INTEGER NOT NULL DEFAULT 0,
+	state        TEXT NOT NULL DEFAULT 'pending',
+	run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	last_error   TEXT,
+	locked_until TIMESTAMPTZ,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (queue, state, priority DESC, run_at)`,
+		q.table, q.index)
+
+	if _, err := q.db.ExecContext(ctx, create); err != nil {
+		return fmt.Errorf("problem creating jobs table: %w", err)
+	}
+	return nil
+}
+
+func (q *queue) Enqueue(ctx context.Context, queue string, payload interface{}, opts *EnqueueOptions) (int64, error) {
+	if opts == nil {
+		opts = &EnqueueOptions{}
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = q.maxAttempts
+	}
+	var runAt interface{}
+	if !opts.RunAt.IsZero() {
+		runAt = opts.RunAt
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("problem encoding job payload: %w", err)
+	}
+
+	insert := fmt.Sprintf(`INSERT INTO %s (queue, payload, priority, run_at, max_attempts)
+VALUES ($1, $2, $3, COALESCE($4, now()), $5) RETURNING id`, q.table)
+
+	var id int64
+	err = q.db.QueryRowContext(ctx, insert, queue, string(data), opts.Priority, runAt, maxAttempts).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("problem enqueueing job: %w", persistence.ClassifyError(err))
+	}
+	return id, nil
+}
+
+func (q *queue) Dequeue(ctx context.Context, queue string) (Job, bool, error) {
+	// jobs whose visibility timeout expired are taken over, unless they used
+	// up their attempts, in which case they are moved to the dead state
+	dequeue := fmt.Sprintf(`WITH dead AS (
+	UPDATE %[1]s SET state = 'dead', last_error = 'visibility timeout expired', locked_until = NULL, updated_at = now()
+	WHERE id IN (
+		SELECT id FROM %[1]s
+		WHERE queue = $1 AND state = 'running' AND locked_until <= now() AND attempts >= max_attempts
+		FOR UPDATE SKIP LOCKED
+	)
+)
+UPDATE %[1]s SET state = 'running', attempts = attempts + 1,
+	locked_until = now() + $2::float8 * INTERVAL '1 millisecond', updated_at = now()
+WHERE id = (
+	SELECT id FROM %[1]s
+	WHERE queue = $1 AND (
+		(state = 'pending' AND run_at <= now()) OR
+		(state = 'running' AND locked_until <= now() AND attempts < max_attempts))
+	ORDER BY priority DESC, run_at, id
+	FOR UPDATE SKIP LOCKED
+	LIMIT 1
+)
+RETURNING id, queue, payload, priority, run_at, attempts, max_attempts, created_at`, q.table)
+
+	// the deadline is taken from the local clock, before the job is locked, so
+	// that clock skew with the database cannot extend it
+	lockedUntil := time.Now().Add(q.visibilityTimeout)
+
+	var job Job
+	var payload []byte
+	err := q.db.QueryRowContext(ctx, dequeue, queue, q.visibilityTimeout.Milliseconds()).Scan(
+		&job.ID, &job.Queue, &payload, &job.Priority, &job.RunAt, &job.Attempts, &job.MaxAttempts, &job.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, fmt.Errorf("problem dequeueing job: %w", persistence.ClassifyError(err))
+	}
+	job.LockedUntil = lockedUntil
+	job.Payload = payload
+	return job, true, nil
+}
+
+func (q *queue) Complete(ctx context.Context, job Job) error {
+	update := fmt.Sprintf(`UPDATE %s SET state = 'done', locked_until = NULL, updated_at = now()
+WHERE id = $1 AND state = 'running' AND attempts = $2`, q.table)
+
+	return q.update(ctx, job, "completing", update, job.ID, job.Attempts)
+}
+
+func (q *queue) Fail(ctx context.Context, job Job, cause error) error {
+	// last_error is left NULL when the cause is not known
+	var lastError interface{}
+	if cause != nil {
+		lastError = cause.Error()
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		q.logger.Warnf("job %d on queue %s failed %d times and is dead: %v", job.ID, job.Queue, job.Attempts, cause)
+		update := fmt.Sprintf(`UPDATE %s SET state = 'dead', last_error = $3, locked_until = NULL, updated_at = now()
+WHERE id = $1 AND state = 'running' AND attempts = $2`, q.table)
+		return q.update(ctx, job, "failing", update, job.ID, job.Attempts, lastError)
+	}
+
+	delay := retryDelay(q.initialBackoff, q.maxBackoff, job.Attempts)
+	update := fmt.Sprintf(`UPDATE %s SET state = 'pending', last_error = $3, locked_until = NULL,
+	run_at = now() + $4::float8 * INTERVAL '1 millisecond', updated_at = now()
+WHERE id = $1 AND state = 'running' AND attempts = $2`, q.table)
+	return q.update(ctx, job, "failing", update, job.ID, job.Attempts, lastError, delay.Milliseconds())
+}
+
+func (q *queue) Release(ctx context.Context, job Job) error {
+	update := fmt.Sprintf(`UPDATE %s SET state = 'pending', attempts = attempts - 1, locked_until = NULL, updated_at = now()
+WHERE id = $1 AND state = 'running' AND attempts = $2`, q.table)
+
+	return q.update(ctx, job, "releasing", update, job.ID, job.Attempts)
+}
+
+// update runs a statement changing a job held by this worker, reporting ErrJobLost when it no longer is
+func (q *queue) update(ctx context.Context, job Job, action, update string, args ...interface{}) error {
+	result, err := q.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return fmt.Errorf("problem %s job %d: %w", action, job.ID, err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("problem %s job %d: %w", action, job.ID, ErrJobLost)
+	}
+	return nil
+}
+
+// retryDelay doubles initial for every attempt after the first, up to maxDelay
+func retryDelay(initial, maxDelay time.Duration, attempt int) time.Duration {
+	delay := initial
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
@@ -0,0 +1,166 @@
+package queue
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/persistence"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+func TestRetryDelay(t *testing.T) {
+	initial, maxDelay := time.Second, 10*time.Second
+
+	assert.Equal(t, time.Second, retryDelay(initial, maxDelay, 1))
+	assert.Equal(t, 2*time.Second, retryDelay(initial, maxDelay, 2))
+	assert.Equal(t, 8*time.Second, retryDelay(initial, maxDelay, 4))
+	assert.Equal(t, maxDelay, retryDelay(initial, maxDelay, 5))
+	assert.Equal(t, maxDelay, retryDelay(initial, maxDelay, 100))
+}
+
+func TestNewDefaults(t *testing.T) {
+	q := New(logtest.NewNopLogger(), persistence.DB{}, Options{}).(*queue)
+
+	assert.Equal(t, `"jobs"`, q.table)
+	assert.Equal(t, `"jobs_dequeue_idx"`, q.index)
+	assert.Equal(t, defaultMaxAttempts, q.maxAttempts)
+	assert.Equal(t, defaultVisibilityTimeout, q.visibilityTimeout)
+	assert.Equal(t, defaultInitialBackoff, q.initialBackoff)
+	assert.Equal(t, defaultMaxBackoff, q.maxBackoff)
+
+	q = New(logtest.NewNopLogger(), persistence.DB{}, Options{Table: "email_jobs", InitialBackoff: time.Minute, MaxBackoff: time.Second}).(*queue)
+	assert.Equal(t, `"email_jobs"`, q.table)
+	assert.Equal(t, defaultMaxBackoff, q.maxBackoff)
+}
+
+// jobsDB scripts a jobs table: Dequeue returns row when it is not nil, and
+// updates affect affected rows
+func jobsDB(t *testing.T, row []driver.Value, affected int64) (*sqltest.Driver, Queue) {
+	fake := &sqltest.Driver{
+		Query: func(query string, _ []interface{}) (driver.Rows, error) {
+			switch {
+			case strings.HasPrefix(query, "INSERT"):
+				return sqltest.NewRows("id", int64(42)), nil
+			case row != nil:
+				return &sqltest.Rows{
+					ColumnNames: []string{"id", "queue", "payload", "priority", "run_at", "attempts", "max_attempts", "created_at"},
+					Values:      [][]driver.Value{row},
+				}, nil
+			}
+			return &sqltest.Rows{}, nil
+		},
+		Exec: func(context.Context, string, []interface{}) (driver.Result, error) {
+			return driver.RowsAffected(affected), nil
+		},
+	}
+	db := persistence.NewDBFromConn(fake.Open(t))
+	return fake, New(logtest.NewNopLogger(), db, Options{VisibilityTimeout: time.Minute, InitialBackoff: time.Second, MaxBackoff: time.Minute})
+}
+
+func TestEnqueue(t *testing.T) {
+	fake, q := jobsDB(t, nil, 1)
+	runAt := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	id, err := q.Enqueue(context.Background(), "emails", map[string]string{"to": "a@example.com"}, &EnqueueOptions{Priority: 3, RunAt: runAt})
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+
+	_, err = q.Enqueue(context.Background(), "emails", "hi", nil)
+	require.NoError(t, err)
+
+	statements := fake.Statements()
+	require.Len(t, statements, 2)
+	assert.Contains(t, statements[0].Query, `INSERT INTO "jobs"`)
+	assert.Equal(t, []interface{}{"emails", `{"to":"a@example.com"}`, int64(3), runAt, int64(defaultMaxAttempts)}, statements[0].Args)
+	assert.Nil(t, statements[1].Args[3], "run_at defaults to now()")
+}
+
+func TestDequeue(t *testing.T) {
+	created := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	fake, q := jobsDB(t, []driver.Value{int64(7), "emails", []byte(`{"to":"a"}`), int64(5), created, int64(2), int64(5), created}, 1)
+
+	before := time.Now()
+	job, found, err := q.Dequeue(context.Background(), "emails")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, int64(7), job.ID)
+	assert.Equal(t, 2, job.Attempts)
+	assert.JSONEq(t, `{"to":"a"}`, string(job.Payload))
+	assert.WithinDuration(t, before.Add(time.Minute), job.LockedUntil, time.Second, "the deadline is taken from the local clock")
+
+	statement := fake.Statements()[0]
+	assert.Equal(t, []interface{}{"emails", int64(time.Minute / time.Millisecond)}, statement.Args)
+	// pending jobs that are due, or running jobs whose visibility timeout expired, by priority then run_at
+	assert.Contains(t, statement.Query, "(state = 'pending' AND run_at <= now()) OR")
+	assert.Contains(t, statement.Query, "(state = 'running' AND locked_until <= now() AND attempts < max_attempts))")
+	assert.Contains(t, statement.Query, "ORDER BY priority DESC, run_at, id")
+	// expired jobs out of attempts are dead lettered, skipping rows other workers hold
+	assert.Contains(t, statement.Query, "SET state = 'dead'")
+	assert.Contains(t, statement.Query, "attempts >= max_attempts\n\t\tFOR UPDATE SKIP LOCKED")
+	assert.Equal(t, 2, strings.Count(statement.Query, "FOR UPDATE SKIP LOCKED"))
+
+	_, q = jobsDB(t, nil, 1)
+	_, found, err = q.Dequeue(context.Background(), "emails")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestJobOutcomes(t *testing.T) {
+	fake, q := jobsDB(t, nil, 1)
+	job := Job{ID: 7, Queue: "emails", Attempts: 2, MaxAttempts: 3}
+
+	require.NoError(t, q.Complete(context.Background(), job))
+	require.NoError(t, q.Fail(context.Background(), job, errors.New("smtp down")))
+	require.NoError(t, q.Release(context.Background(), job))
+	job.Attempts = 3
+	require.NoError(t, q.Fail(context.Background(), job, errors.New("smtp down")))
+
+	statements := fake.Statements()
+	require.Len(t, statements, 4)
+
+	assert.Contains(t, statements[0].Query, "SET state = 'done'")
+	assert.Equal(t, []interface{}{int64(7), int64(2)}, statements[0].Args)
+
+	assert.Contains(t, statements[1].Query, "SET state = 'pending'")
+	assert.Equal(t, []interface{}{int64(7), int64(2), "smtp down", int64(2 * time.Second / time.Millisecond)}, statements[1].Args)
+
+	assert.Contains(t, statements[2].Query, "attempts = attempts - 1")
+	assert.Contains(t, statements[2].Query, "SET state = 'pending'")
+
+	assert.Contains(t, statements[3].Query, "SET state = 'dead'")
+	assert.Equal(t, []interface{}{int64(7), int64(3), "smtp down"}, statements[3].Args)
+}
+
+func TestFailWithoutCause(t *testing.T) {
+	fake, q := jobsDB(t, nil, 1)
+	job := Job{ID: 7, Queue: "emails", Attempts: 1, MaxAttempts: 1}
+
+	require.NoError(t, q.Fail(context.Background(), job, nil))
+	assert.Equal(t, []interface{}{int64(7), int64(1), nil}, fake.Statements()[0].Args)
+}
+
+func TestJobLost(t *testing.T) {
+	_, q := jobsDB(t, nil, 0)
+	job := Job{ID: 7, Attempts: 1, MaxAttempts: 3}
+
+	assert.ErrorIs(t, q.Complete(context.Background(), job), ErrJobLost)
+	assert.ErrorIs(t, q.Fail(context.Background(), job, errors.New("boom")), ErrJobLost)
+	assert.ErrorIs(t, q.Release(context.Background(), job), ErrJobLost)
+}
+
+func TestSchemaQualifiedTable(t *testing.T) {
+	fake := &sqltest.Driver{}
+	q := New(logtest.NewNopLogger(), persistence.NewDBFromConn(fake.Open(t)), Options{Table: "work.jobs"})
+
+	require.NoError(t, q.CreateTable(context.Background()))
+	query := fake.Queries()[0]
+	assert.Contains(t, query, `CREATE TABLE IF NOT EXISTS "work"."jobs"`)
+	assert.Contains(t, query, `CREATE INDEX IF NOT EXISTS "jobs_dequeue_idx" ON "work"."jobs"`)
+}
@@ -0,0 +1,163 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+const (
+	defaultConcurrency  = 1
+	defaultPollInterval = time.Second
+)
+
+// Handler processes a job. Returning an error, or panicking, fails the job so
+// that it is retried or dead lettered. ctx is done when the job's visibility
+// timeout expires or the pool is stopped past its deadline.
+type Handler func(ctx context.Context, job Job) error
+
+// WorkerOptions configure a WorkerPool
+type WorkerOptions struct {
+	// Concurrency is the number of jobs processed at once, defaults to 1
+	Concurrency int
+	// PollInterval is how long an idle worker waits before looking for jobs again, defaults to 1s
+	PollInterval time.Duration
+}
+
+// WorkerPool processes the jobs of a queue with a fixed number of workers
+type WorkerPool interface {
+	// Start starts the workers
+	Start()
+	// Stop stops taking new jobs and waits for the running ones to finish. If
+	// ctx is done first, the running jobs are cancelled and released without
+	// counting the attempt, and ctx's error is returned.
+	Stop(ctx context.Context) error
+}
+
+type workerPool struct {
+	logger       log.Logger
+	queue        Queue
+	name         string
+	handler      Handler
+	concurrency  int
+	pollInterval time.Duration
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	jobsCtx   context.Context
+	abort     context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewWorkerPool returns a WorkerPool running handler for the jobs of the queue named name
+func NewWorkerPool(logger log.Logger, q Queue, name string, handler Handler, opts WorkerOptions) WorkerPool {
+	p := &workerPool{
+		logger:       logger,
+		queue:        q,
+		name:         name,
+		handler:      handler,
+		concurrency:  opts.Concurrency,
+		pollInterval: opts.PollInterval,
+		stop:         make(chan struct{}),
+	}
+	if p.concurrency <= 0 {
+		p.concurrency = defaultConcurrency
+	}
+	if p.pollInterval <= 0 {
+		p.pollInterval = defaultPollInterval
+	}
+	p.jobsCtx, p.abort = context.WithCancel(context.Background())
+	return p
+}
+
+func (p *workerPool) Start() {
+	p.startOnce.Do(func() {
+		for i := 0; i < p.concurrency; i++ {
+			p.wg.Add(1)
+			go p.work()
+		}
+	})
+}
+
+func (p *workerPool) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.abort()
+		return nil
+	case <-ctx.Done():
+		p.logger.Warnf("stopping workers of queue %s timed out, cancelling running jobs", p.name)
+		p.abort()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		job, found, err := p.queue.Dequeue(p.jobsCtx, p.name)
+		if err != nil {
+			p.logger.Warnf("problem dequeueing from queue %s: %v", p.name, err)
+		}
+		if !found {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(p.pollInterval):
+			}
+			continue
+		}
+
+		p.process(job)
+	}
+}
+
+func (p *workerPool) process(job Job) {
+	ctx, cancel := context.WithDeadline(p.jobsCtx, job.LockedUntil)
+	err := p.run(ctx, job)
+	cancel()
+
+	// the outcome is recorded even when the job was cancelled, so that it is retried
+	switch {
+	case err == nil:
+		err = p.queue.Complete(context.Background(), job)
+	case p.jobsCtx.Err() != nil:
+		// the pool was stopped, which is not the job's fault
+		p.logger.Infof("job %d on queue %s was cancelled by stopping its worker, releasing it", job.ID, p.name)
+		err = p.queue.Release(context.Background(), job)
+	default:
+		p.logger.Debugf("job %d on queue %s failed on attempt %d: %v", job.ID, p.name, job.Attempts, err)
+		err = p.queue.Fail(context.Background(), job, err)
+	}
+	if err != nil {
+		p.logger.Warnf("problem recording outcome of job %d on queue %s: %v", job.ID, p.name, err)
+	}
+}
+
+func (p *workerPool) run(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job handler panicked: %v", r)
+		}
+	}()
+	return p.handler(ctx, job)
+}
@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+)
+
+// memoryQueue is an in-memory Queue recording the outcome of every job
+type memoryQueue struct {
+	mu        sync.Mutex
+	pending   []Job
+	completed []int64
+	failed    map[int64]error
+	released  []int64
+}
+
+func newMemoryQueue(jobs ...Job) *memoryQueue {
+	return &memoryQueue{pending: jobs, failed: map[int64]error{}}
+}
+
+func (m *memoryQueue) CreateTable(context.Context) error { return nil }
+
+func (m *memoryQueue) Enqueue(context.Context, string, interface{}, *EnqueueOptions) (int64, error) {
+	return 0, errors.New("not supported")
+}
+
+func (m *memoryQueue) Dequeue(_ context.Context, name string) (Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pending) == 0 {
+		return Job{}, false, nil
+	}
+	job := m.pending[0]
+	m.pending = m.pending[1:]
+	job.Queue = name
+	job.Attempts++
+	job.LockedUntil = time.Now().Add(time.Minute)
+	return job, true, nil
+}
+
+func (m *memoryQueue) Complete(_ context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.completed = append(m.completed, job.ID)
+	return nil
+}
+
+func (m *memoryQueue) Fail(_ context.Context, job Job, cause error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed[job.ID] = cause
+	return nil
+}
+
+func (m *memoryQueue) Release(_ context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.released = append(m.released, job.ID)
+	return nil
+}
+
+func (m *memoryQueue) releasedJobs() []int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int64(nil), m.released...)
+}
+
+func (m *memoryQueue) outcomes() ([]int64, map[int64]error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int64(nil), m.completed...), m.failed
+}
+
+func TestWorkerPoolProcessesJobs(t *testing.T) {
+	q := newMemoryQueue(Job{ID: 1}, Job{ID: 2}, Job{ID: 3}, Job{ID: 4})
+	handler := func(_ context.Context, job Job) error {
+		switch job.ID {
+		case 2:
+			return errors.New("boom")
+		case 3:
+			panic("bad payload")
+		}
+		return nil
+	}
+
+	pool := NewWorkerPool(logtest.NewNopLogger(), q, "emails", handler, WorkerOptions{Concurrency: 2, PollInterval: time.Millisecond})
+	pool.Start()
+	require.Eventually(t, func() bool {
+		completed, failed := q.outcomes()
+		return len(completed)+len(failed) == 4
+	}, time.Second, time.Millisecond)
+	require.NoError(t, pool.Stop(context.Background()))
+
+	completed, failed := q.outcomes()
+	assert.ElementsMatch(t, []int64{1, 4}, completed)
+	assert.EqualError(t, failed[2], "boom")
+	assert.ErrorContains(t, failed[3], "panicked: bad payload")
+}
+
+func TestWorkerPoolStopWaitsForRunningJobs(t *testing.T) {
+	q := newMemoryQueue(Job{ID: 1})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := func(context.Context, Job) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	pool := NewWorkerPool(logtest.NewNopLogger(), q, "emails", handler, WorkerOptions{PollInterval: time.Millisecond})
+	pool.Start()
+	<-started
+
+	stopped := make(chan error)
+	go func() { stopped <- pool.Stop(context.Background()) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned while a job was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-stopped)
+
+	completed, _ := q.outcomes()
+	assert.Equal(t, []int64{1}, completed)
+}
+
+func TestWorkerPoolStopCancelsJobsAtDeadline(t *testing.T) {
+	q := newMemoryQueue(Job{ID: 1})
+	started := make(chan struct{})
+	handler := func(ctx context.Context, _ Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	pool := NewWorkerPool(logtest.NewNopLogger(), q, "emails", handler, WorkerOptions{PollInterval: time.Millisecond})
+	pool.Start()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pool.Stop(ctx), context.DeadlineExceeded)
+
+	_, failed := q.outcomes()
+	assert.Empty(t, failed, "stopping must not use up an attempt")
+	assert.Equal(t, []int64{1}, q.releasedJobs())
+}
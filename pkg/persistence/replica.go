@@ -0,0 +1,210 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+const defaultReplicaCheckInterval = 10 * time.Second
+
+// replicaHost is the address of a read replica
+type replicaHost struct {
+	Host string
+	Port string
+}
+
+// replica is a read replica pool and whether its last health check passed
+type replica struct {
+	host    string
+	db      *sql.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// setHealthy records the health of r, returning whether it changed
+func (r *replica) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	return atomic.SwapInt32(&r.healthy, v) != v
+}
+
+// replicaSet routes reads round-robin to its healthy replicas. logger may be nil.
+type replicaSet struct {
+	logger   log.Logger
+	replicas []*replica
+	next     uint32
+	stop     context.CancelFunc
+	done     chan struct{}
+}
+
+// newReplicaSet checks every replica once, then keeps checking them every interval until close
+func newReplicaSet(logger log.Logger, replicas []*replica, interval time.Duration) *replicaSet {
+	ctx, stop := context.WithCancel(context.Background())
+	s := &replicaSet{logger: logger, replicas: replicas, stop: stop, done: make(chan struct{})}
+	s.check(ctx)
+	go s.checkEvery(ctx, interval)
+	return s
+}
+
+// pick returns the next healthy replica, or nil when there is none
+func (s *replicaSet) pick() *replica {
+	if s == nil {
+		return nil
+	}
+	n := uint32(len(s.replicas))
+	start := atomic.AddUint32(&s.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if r := s.replicas[(start+i)%n]; r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *replicaSet) checkEvery(ctx context.Context, interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.check(ctx)
+		}
+	}
+}
+
+// check pings every replica concurrently and records which are healthy
+func (s *replicaSet) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range s.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			err := r.db.PingContext(pingCtx)
+			if ctx.Err() != nil {
+				return
+			}
+			if !r.setHealthy(err == nil) {
+				return
+			}
+			if err != nil {
+				s.warnf("read replica %s is unhealthy, routing its reads elsewhere: %v", r.host, err)
+			} else if s.logger != nil {
+				s.logger.Infof("read replica %s is healthy", r.host)
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+// markUnhealthy takes r out of rotation until its next successful health check
+func (s *replicaSet) markUnhealthy(r *replica, err error) {
+	if r.setHealthy(false) {
+		s.warnf("read replica %s failed, routing its reads elsewhere: %v", r.host, err)
+	}
+}
+
+func (s *replicaSet) warnf(format string, args ...interface{}) {
+	if s.logger != nil {
+		s.logger.Warnf(format, args...)
+	}
+}
+
+// close stops the health checks and closes every replica pool
+func (s *replicaSet) close() error {
+	s.stop()
+	<-s.done
+
+	var firstErr error
+	for _, r := range s.replicas {
+		if err := r.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// pools returns the replica pools
+func (s *replicaSet) pools() []*sql.DB {
+	if s == nil {
+		return nil
+	}
+	pools := make([]*sql.DB, len(s.replicas))
+	for i, r := range s.replicas {
+		pools[i] = r.db
+	}
+	return pools
+}
+
+// isFailoverError reports whether err means the replica itself failed, so the
+// statement should be retried on the primary
+func isFailoverError(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && errors.Is(ClassifyError(err), ErrConnection)
+}
+
+// reader returns a healthy replica when readOnly is true, otherwise the
+// primary. The replica is nil for the primary.
+func (d DB) reader(readOnly bool) (*sql.DB, *replica) {
+	if !readOnly {
+		return d.DB, nil
+	}
+	if r := d.replicas.pick(); r != nil {
+		return r.db, r
+	}
+	return d.DB, nil
+}
+
+// withReplicas returns a copy of d that routes reads to replicas, checking
+// their health every interval. The replicas are closed with d.
+func (d DB) withReplicas(replicas []*replica, interval time.Duration) DB {
+	d.replicas = newReplicaSet(d.logger, replicas, interval)
+	return d
+}
+
+// parseReplicaHosts parses a comma separated list of host or host:port
+// addresses, using defaultPort when a port is omitted
+func parseReplicaHosts(r *configReader, key, value, defaultPort string) []replicaHost {
+	var hosts []replicaHost
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if len(address) == 0 {
+			continue
+		}
+
+		host, port := address, defaultPort
+		if strings.Contains(address, ":") {
+			var err error
+			host, port, err = net.SplitHostPort(address)
+			if err != nil {
+				r.addProblem(newInvalidConfigurationError(key, address))
+				continue
+			}
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || len(host) == 0 {
+			r.addProblem(newInvalidConfigurationError(key, address))
+			continue
+		}
+		hosts = append(hosts, replicaHost{Host: host, Port: port})
+	}
+	return hosts
+}
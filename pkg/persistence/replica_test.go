@@ -0,0 +1,135 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+// newReplicatedDB returns a DB over primary routing reads to replicas, which are not health checked again
+func newReplicatedDB(t *testing.T, primary *sqltest.Driver, replicas ...*sqltest.Driver) DB {
+	set := make([]*replica, len(replicas))
+	for i, fake := range replicas {
+		set[i] = &replica{host: "replica", db: sql.OpenDB(fake)}
+	}
+	d := newFakeDB(t, primary).withReplicas(set, time.Hour)
+	t.Cleanup(func() { _ = d.replicas.close() })
+	return d
+}
+
+func readQuery(t *testing.T, d DB) {
+	t.Helper()
+	rows, err := d.ReadQueryContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+}
+
+func TestReadQueriesUseReplicas(t *testing.T) {
+	primary, first, second := &sqltest.Driver{}, &sqltest.Driver{}, &sqltest.Driver{}
+	d := newReplicatedDB(t, primary, first, second)
+
+	for i := 0; i < 2; i++ {
+		readQuery(t, d)
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, d.ReadQueryRowContext(context.Background(), "SELECT 1").Err())
+	}
+	assert.Equal(t, 2, len(first.Queries()))
+	assert.Equal(t, 2, len(second.Queries()))
+	assert.Equal(t, 0, len(primary.Queries()))
+}
+
+func TestWritesUsePrimary(t *testing.T) {
+	primary, replica := &sqltest.Driver{Query: func(string, []interface{}) (driver.Rows, error) {
+		return &sqltest.Rows{ColumnNames: []string{"id"}, Values: [][]driver.Value{{int64(1)}}}, nil
+	}}, &sqltest.Driver{}
+	d := newReplicatedDB(t, primary, replica)
+
+	var id int64
+	require.NoError(t, d.QueryRowContext(context.Background(), "INSERT INTO jobs (queue) VALUES ($1) RETURNING id", "mail").Scan(&id))
+	rows, err := d.QueryContext(context.Background(), "UPDATE jobs SET attempts = attempts + 1 RETURNING id")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	_, err = d.ExecContext(context.Background(), "DELETE FROM users")
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, len(primary.Queries()))
+	assert.Equal(t, 0, len(replica.Queries()), "only ReadQueryContext and ReadQueryRowContext use replicas")
+}
+
+func TestReadOnlyTransactionsUseReplicas(t *testing.T) {
+	primary, replica := &sqltest.Driver{}, &sqltest.Driver{}
+	d := newReplicatedDB(t, primary, replica)
+
+	noop := func(*sql.Tx) error { return nil }
+	require.NoError(t, d.WithTx(context.Background(), &TxOptions{ReadOnly: true}, noop))
+	require.NoError(t, d.WithTx(context.Background(), nil, noop))
+
+	assert.Equal(t, 1, replica.Count("BEGIN"))
+	assert.Equal(t, 1, primary.Count("BEGIN"))
+}
+
+func TestReadsFailOverToPrimary(t *testing.T) {
+	primary := &sqltest.Driver{}
+	replica := &sqltest.Driver{Query: func(string, []interface{}) (driver.Rows, error) {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	}}
+	d := newReplicatedDB(t, primary, replica)
+
+	readQuery(t, d)
+	assert.Equal(t, 1, len(replica.Queries()))
+	assert.Equal(t, 1, len(primary.Queries()))
+
+	readQuery(t, d)
+	assert.Equal(t, 1, len(replica.Queries()), "the failed replica is out of rotation")
+	assert.Equal(t, 2, len(primary.Queries()))
+}
+
+func TestReplicaHealthChecks(t *testing.T) {
+	primary, replica := &sqltest.Driver{}, &sqltest.Driver{ConnectErr: errors.New("connection refused")}
+	d := newReplicatedDB(t, primary, replica)
+
+	readQuery(t, d)
+	assert.Equal(t, 1, len(primary.Queries()), "unhealthy replicas are skipped")
+
+	replica.SetConnectErr(nil)
+	d.replicas.check(context.Background())
+	readQuery(t, d)
+	assert.Equal(t, 1, len(replica.Queries()))
+}
+
+func TestParseDBConfigurationReplicaHosts(t *testing.T) {
+	cfg := newTestConfig(t, map[string]interface{}{
+		"DB_USER":          "boom",
+		"DB_PASSWORD":      "password",
+		"DB_NAME":          "some_db_name",
+		"DB_PORT":          "6432",
+		"DB_REPLICA_HOSTS": "replica-1, replica-2:5433,[::1]:5434",
+	})
+
+	dbCfg, err := parseDBConfiguration(logtest.NewNopLogger(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []replicaHost{{"replica-1", "6432"}, {"replica-2", "5433"}, {"::1", "5434"}}, dbCfg.Replicas)
+	assert.Equal(t, defaultReplicaCheckInterval, dbCfg.ReplicaCheckInterval)
+
+	cfg = newTestConfig(t, map[string]interface{}{
+		"DB_USER":          "boom",
+		"DB_PASSWORD":      "password",
+		"DB_NAME":          "some_db_name",
+		"DB_REPLICA_HOSTS": "replica-1:port,:5432",
+	})
+	_, err = parseDBConfiguration(logtest.NewNopLogger(), cfg)
+	var cfgErr *ConfigurationError
+	require.ErrorAs(t, err, &cfgErr)
+	assert.Len(t, cfgErr.Problems, 2)
+	assert.ErrorIs(t, err, ErrInvalidConfiguration)
+}
@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/zhughes3/elliot/pkg/log"
+)
+
+const (
+	defaultConnectMaxAttempts    = 5
+	defaultConnectInitialBackoff = 500 * time.Millisecond
+	defaultConnectMaxBackoff     = 10 * time.Second
+	defaultConnectRetryDeadline  = time.Minute
+)
+
+// retryConfig controls how often the initial connection to the database is
+// attempted. A zero MaxAttempts or Deadline means no limit on that dimension,
+// but the zero value as a whole makes a single attempt.
+type retryConfig struct {
+	MaxAttempts int
+	Deadline    time.Duration
+	Backoff     backoff
+}
+
+// parseRetryConfiguration reads the initial connection retry settings from configuration
+func parseRetryConfiguration(r *configReader) retryConfig {
+	retry := retryConfig{
+		MaxAttempts: r.nonNegativeInt("DB_CONNECT_MAX_ATTEMPTS", defaultConnectMaxAttempts),
+		Deadline:    r.nonNegativeDuration("DB_CONNECT_RETRY_DEADLINE", defaultConnectRetryDeadline),
+		Backoff: backoff{
+			// a zero wait would make pingWithRetry ping in a tight loop
+			Initial: r.positiveDuration("DB_CONNECT_INITIAL_BACKOFF", defaultConnectInitialBackoff),
+			Max:     r.nonNegativeDuration("DB_CONNECT_MAX_BACKOFF", defaultConnectMaxBackoff),
+		},
+	}
+	if retry.Backoff.Max > 0 && retry.Backoff.Initial > retry.Backoff.Max {
+		r.addProblem(fmt.Errorf("%w: DB_CONNECT_INITIAL_BACKOFF (%s) cannot exceed DB_CONNECT_MAX_BACKOFF (%s)", ErrInvalidConfiguration, retry.Backoff.Initial, retry.Backoff.Max))
+	}
+	return retry
+}
+
+// pingWithRetry pings db until it responds, the attempts or deadline in cfg
+// are exhausted, or ctx is done
+func pingWithRetry(ctx context.Context, logger log.Logger, db *sql.DB, cfg retryConfig) error {
+	if cfg.MaxAttempts == 0 && cfg.Deadline == 0 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Deadline)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if cfg.MaxAttempts > 0 && attempt >= cfg.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := cfg.Backoff.duration(attempt)
+		logger.Warnf("attempt %d to reach postgres failed, retrying in %s: %v", attempt, wait, err)
+		if ctxErr := sleepContext(ctx, wait); ctxErr != nil {
+			return fmt.Errorf("giving up after %d attempts: %v: %w", attempt, err, ctxErr)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+)
+
+func TestBackoffDuration(t *testing.T) {
+	b := backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+
+	tcs := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	}
+
+	for _, tc := range tcs {
+		wait := b.duration(tc.attempt)
+		assert.GreaterOrEqual(t, wait, tc.base/2, "attempt %d", tc.attempt)
+		assert.LessOrEqual(t, wait, tc.base, "attempt %d", tc.attempt)
+	}
+}
+
+// unreachableDB returns a sql.DB pointing at a port nothing listens on
+func unreachableDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("postgres", "postgres://127.0.0.1:1/none?sslmode=disable&connect_timeout=1")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestPingWithRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	logger := logtest.NewRecorder()
+	cfg := retryConfig{MaxAttempts: 3, Backoff: backoff{Initial: time.Millisecond, Max: time.Millisecond}}
+
+	err := pingWithRetry(context.Background(), logger, unreachableDB(t), cfg)
+	assert.ErrorContains(t, err, "giving up after 3 attempts")
+	assert.Len(t, logger.Entries(), 2)
+}
+
+func TestPingWithRetryHonoursContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cfg := retryConfig{Backoff: backoff{Initial: time.Hour}, Deadline: time.Hour}
+
+	start := time.Now()
+	err := pingWithRetry(ctx, logtest.NewNopLogger(), unreachableDB(t), cfg)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestParseRetryConfigurationRejectsZeroInitialBackoff(t *testing.T) {
+	r := newConfigReader(logtest.NewNopLogger(), newTestConfig(t, map[string]interface{}{
+		"DB_CONNECT_INITIAL_BACKOFF": "0s",
+	}))
+
+	retry := parseRetryConfiguration(r)
+	assert.ErrorIs(t, r.err(), ErrInvalidConfiguration)
+	assert.ErrorContains(t, r.err(), "DB_CONNECT_INITIAL_BACKOFF")
+	assert.Equal(t, defaultConnectInitialBackoff, retry.Backoff.Initial)
+}
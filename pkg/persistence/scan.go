@@ -0,0 +1,175 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnmappedColumns is returned when a query returns columns without a matching struct field
+	ErrUnmappedColumns = errors.New("columns have no matching struct field")
+	// ErrMultipleRows is returned by QueryOne when the query returns more than one row
+	ErrMultipleRows = errors.New("query returned more than one row")
+)
+
+// Queryer runs queries returning rows. It is implemented by DB, *sql.DB, *sql.Tx and *sql.Conn.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// QueryAll runs query and scans every row into a T. See QueryEach for how
+// columns are mapped to fields.
+func QueryAll[T any](ctx context.Context, q Queryer, query string, args ...interface{}) ([]T, error) {
+	var all []T
+	err := QueryEach(ctx, q, func(t T) error {
+		all = append(all, t)
+		return nil
+	}, query, args...)
+	return all, err
+}
+
+// QueryOne runs query and scans its only row into a T. It returns
+// sql.ErrNoRows when there is no row and ErrMultipleRows when there is more than one.
+func QueryOne[T any](ctx context.Context, q Queryer, query string, args ...interface{}) (T, error) {
+	var one T
+	var found bool
+	err := QueryEach(ctx, q, func(t T) error {
+		if found {
+			return ErrMultipleRows
+		}
+		one, found = t, true
+		return nil
+	}, query, args...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if !found {
+		return one, sql.ErrNoRows
+	}
+	return one, nil
+}
+
+// QueryEach runs query and calls fn with every row scanned into a T, stopping
+// at the first error fn returns. T must be a struct; each column is scanned
+// into the field tagged `db:"<column>"`, or else the field whose lower cased
+// name matches. Fields of embedded structs are included and `db:"-"` skips a
+// field. Nullable columns need a pointer or sql.Null* field. A column without
+// a matching field is reported with ErrUnmappedColumns.
+func QueryEach[T any](ctx context.Context, q Queryer, fn func(T) error, query string, args ...interface{}) error {
+	info, err := structInfoFor(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return err
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	indexes, err := info.indexesFor(columns)
+	if err != nil {
+		return err
+	}
+
+	dest := make([]interface{}, len(columns))
+	for rows.Next() {
+		var t T
+		v := reflect.ValueOf(&t).Elem()
+		for i, index := range indexes {
+			dest[i] = v.FieldByIndex(index).Addr().Interface()
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return ClassifyError(err)
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return ClassifyError(rows.Err())
+}
+
+// structInfo maps column names to the index path of the struct field they scan into
+type structInfo struct {
+	typ    reflect.Type
+	fields map[string][]int
+}
+
+var structInfoCache sync.Map
+
+func structInfoFor(typ reflect.Type) (*structInfo, error) {
+	if cached, ok := structInfoCache.Load(typ); ok {
+		return cached.(*structInfo), nil
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot scan rows into %s, it must be a struct", typ)
+	}
+
+	info := &structInfo{typ: typ, fields: map[string][]int{}}
+	collectFields(typ, nil, info.fields)
+
+	cached, _ := structInfoCache.LoadOrStore(typ, info)
+	return cached.(*structInfo), nil
+}
+
+// collectFields adds the exported fields of typ to fields. Fields closer to the
+// outer struct win over fields of the same name in embedded structs.
+func collectFields(typ reflect.Type, parent []int, fields map[string][]int) {
+	var embedded []reflect.StructField
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+
+		index := append(append([]int(nil), parent...), i)
+		if field.Anonymous && len(tag) == 0 && field.Type.Kind() == reflect.Struct {
+			field.Index = index
+			embedded = append(embedded, field)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name := tag
+		if len(name) == 0 {
+			name = strings.ToLower(field.Name)
+		}
+		if _, exists := fields[name]; !exists {
+			fields[name] = index
+		}
+	}
+
+	for _, field := range embedded {
+		collectFields(field.Type, field.Index, fields)
+	}
+}
+
+func (s *structInfo) indexesFor(columns []string) ([][]int, error) {
+	indexes := make([][]int, len(columns))
+	var unmapped []string
+	for i, column := range columns {
+		index, ok := s.fields[column]
+		if !ok {
+			unmapped = append(unmapped, column)
+			continue
+		}
+		indexes[i] = index
+	}
+	if len(unmapped) > 0 {
+		return nil, fmt.Errorf("%w in %s: %s", ErrUnmappedColumns, s.typ, strings.Join(unmapped, ", "))
+	}
+	return indexes, nil
+}
@@ -0,0 +1,89 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+type timestamps struct {
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type user struct {
+	timestamps
+	ID       int64
+	Email    string         `db:"email_address"`
+	Name     sql.NullString `db:"name"`
+	Nickname *string        `db:"nickname"`
+	Ignored  string         `db:"-"`
+}
+
+func usersDB(t *testing.T, columns []string, values ...[]driver.Value) DB {
+	return newFakeDB(t, &sqltest.Driver{Query: func(string, []interface{}) (driver.Rows, error) {
+		return &sqltest.Rows{ColumnNames: columns, Values: values}, nil
+	}})
+}
+
+func TestQueryAll(t *testing.T) {
+	created := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	db := usersDB(t,
+		[]string{"id", "email_address", "name", "nickname", "created_at"},
+		[]driver.Value{int64(1), "a@example.com", "Ann", nil, created},
+		[]driver.Value{int64(2), "b@example.com", nil, "bee", created},
+	)
+
+	users, err := QueryAll[user](context.Background(), db, "SELECT * FROM users")
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+
+	assert.Equal(t, int64(1), users[0].ID)
+	assert.Equal(t, "a@example.com", users[0].Email)
+	assert.Equal(t, sql.NullString{String: "Ann", Valid: true}, users[0].Name)
+	assert.Nil(t, users[0].Nickname)
+	assert.Equal(t, created, users[0].CreatedAt)
+
+	assert.False(t, users[1].Name.Valid)
+	require.NotNil(t, users[1].Nickname)
+	assert.Equal(t, "bee", *users[1].Nickname)
+}
+
+func TestQueryOne(t *testing.T) {
+	db := usersDB(t, []string{"id"}, []driver.Value{int64(1)})
+	one, err := QueryOne[user](context.Background(), db, "SELECT id FROM users")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), one.ID)
+
+	_, err = QueryOne[user](context.Background(), usersDB(t, []string{"id"}), "SELECT id FROM users")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	db = usersDB(t, []string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+	_, err = QueryOne[user](context.Background(), db, "SELECT id FROM users")
+	assert.ErrorIs(t, err, ErrMultipleRows)
+}
+
+func TestQueryEachReportsUnmappedColumns(t *testing.T) {
+	db := usersDB(t, []string{"id", "password_hash", "ignored"}, []driver.Value{int64(1), "x", "y"})
+
+	err := QueryEach(context.Background(), db, func(user) error { return nil }, "SELECT * FROM users")
+	assert.ErrorIs(t, err, ErrUnmappedColumns)
+	assert.ErrorContains(t, err, "password_hash, ignored")
+}
+
+func TestStructInfoIsCached(t *testing.T) {
+	first, err := structInfoFor(reflect.TypeOf(user{}))
+	require.NoError(t, err)
+	second, err := structInfoFor(reflect.TypeOf(user{}))
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+
+	_, err = structInfoFor(reflect.TypeOf(""))
+	assert.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const shutdownPollInterval = 25 * time.Millisecond
+
+// ErrShutdown is returned for work started through DB after Shutdown was called
+var ErrShutdown = errors.New("database is shutting down")
+
+// tracker records the operations running through DB so that Shutdown can wait
+// for them and abort whatever is left at its deadline
+type tracker struct {
+	mu      sync.Mutex
+	closing bool
+	nextID  uint64
+	ops     map[uint64]*operation
+}
+
+type operation struct {
+	desc    string
+	started time.Time
+	// cancel is nil for operations whose context must outlive the call, such
+	// as queries returning rows
+	cancel context.CancelFunc
+}
+
+func newTracker() *tracker {
+	return &tracker{ops: map[uint64]*operation{}}
+}
+
+// begin registers an operation. When abortable is true the returned context is
+// cancelled if Shutdown reaches its deadline. The returned func must be called
+// once the operation is done.
+func (d DB) begin(ctx context.Context, desc string, abortable bool) (context.Context, func(), error) {
+	t := d.tracker
+	if t == nil {
+		return ctx, func() {}, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return ctx, func() {}, ErrShutdown
+	}
+
+	op := &operation{desc: desc, started: time.Now()}
+	if abortable {
+		ctx, op.cancel = context.WithCancel(ctx)
+	}
+	id := t.nextID
+	t.nextID++
+	t.ops[id] = op
+
+	return ctx, func() {
+		t.mu.Lock()
+		delete(t.ops, id)
+		t.mu.Unlock()
+		if op.cancel != nil {
+			op.cancel()
+		}
+	}, nil
+}
+
+// Shutdown stops DB from accepting new work, waits for running operations and
+// transactions to finish, then closes the pool. If ctx is done first, the
+// operations still running are cancelled and logged before closing. Rows
+// returned by QueryContext are not tracked once the query returned; closing
+// the pool waits for the connections they still hold.
+func (d DB) Shutdown(ctx context.Context) error {
+	t := d.tracker
+	if t == nil {
+		return d.Close()
+	}
+
+	t.mu.Lock()
+	t.closing = true
+	t.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !t.idle(append(d.replicas.pools(), d.DB)...) {
+		select {
+		case <-ctx.Done():
+			aborted := t.abort()
+			for _, op := range aborted {
+				d.warnf("aborting %s after %s", op.desc, time.Since(op.started).Round(time.Millisecond))
+			}
+			if inUse := d.DB.Stats().InUse; inUse > 0 {
+				d.warnf("closing database with %d connections still in use", inUse)
+			}
+			if err := d.Close(); err != nil {
+				return err
+			}
+			return fmt.Errorf("database shut down with %d operations aborted: %w", len(aborted), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return d.Close()
+}
+
+// idle reports whether no tracked operation is running and no connection of dbs is in use
+func (t *tracker) idle(dbs ...*sql.DB) bool {
+	t.mu.Lock()
+	running := len(t.ops)
+	t.mu.Unlock()
+	if running > 0 {
+		return false
+	}
+	for _, db := range dbs {
+		if db.Stats().InUse > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// abort cancels every abortable running operation and returns all of them
+func (t *tracker) abort() []operation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	aborted := make([]operation, 0, len(t.ops))
+	for _, op := range t.ops {
+		if op.cancel != nil {
+			op.cancel()
+		}
+		aborted = append(aborted, *op)
+	}
+	return aborted
+}
+
+func (d DB) warnf(format string, args ...interface{}) {
+	if d.logger != nil {
+		d.logger.Warnf(format, args...)
+	}
+}
@@ -0,0 +1,81 @@
+package persistence
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/log/logtest"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+func TestShutdownWaitsForRunningWork(t *testing.T) {
+	release := make(chan struct{})
+	fake := &sqltest.Driver{Exec: func(context.Context, string, []interface{}) (driver.Result, error) {
+		<-release
+		return driver.RowsAffected(1), nil
+	}}
+	db := newFakeDB(t, fake)
+
+	execErr := make(chan error)
+	go func() {
+		_, err := db.ExecContext(context.Background(), "UPDATE users SET name = 'a'")
+		execErr <- err
+	}()
+	require.Eventually(t, func() bool { return !db.tracker.idle(db.DB) }, time.Second, time.Millisecond)
+
+	shutdownErr := make(chan error)
+	go func() { shutdownErr <- db.Shutdown(context.Background()) }()
+
+	require.Eventually(t, func() bool {
+		_, err := db.ExecContext(context.Background(), "SELECT 1")
+		return err == ErrShutdown
+	}, time.Second, time.Millisecond, "new work should be rejected while shutting down")
+
+	close(release)
+	assert.NoError(t, <-execErr)
+	assert.NoError(t, <-shutdownErr)
+}
+
+func TestShutdownAbortsWorkAtDeadline(t *testing.T) {
+	fake := &sqltest.Driver{Exec: func(ctx context.Context, _ string, _ []interface{}) (driver.Result, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	logger := logtest.NewRecorder()
+	db := newFakeDB(t, fake).WithQueryLogging(logger, 0)
+
+	execErr := make(chan error)
+	go func() {
+		_, err := db.ExecContext(context.Background(), "SELECT pg_sleep(600)")
+		execErr <- err
+	}()
+	require.Eventually(t, func() bool { return !db.tracker.idle(db.DB) }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := db.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, <-execErr, context.Canceled)
+
+	var aborted bool
+	for _, entry := range logger.Entries() {
+		if entry.Level == "warn" && containsAll(entry.Message, "aborting", "pg_sleep") {
+			aborted = true
+		}
+	}
+	assert.True(t, aborted, "aborted work should be logged")
+}
+
+func containsAll(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package persistence
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	sslModeDisable    = "disable"
+	sslModeRequire    = "require"
+	sslModeVerifyCA   = "verify-ca"
+	sslModeVerifyFull = "verify-full"
+)
+
+// isValidSSLMode reports whether mode is supported by lib/pq
+func isValidSSLMode(mode string) bool {
+	switch mode {
+	case sslModeDisable, sslModeRequire, sslModeVerifyCA, sslModeVerifyFull:
+		return true
+	}
+	return false
+}
+
+// isPEM reports whether an ssl setting holds PEM encoded material rather than a file path
+func isPEM(value string) bool {
+	return bytes.HasPrefix(bytes.TrimSpace([]byte(value)), []byte("-----BEGIN"))
+}
+
+// writeSSLMaterial replaces PEM encoded certificates and keys held in cfg with
+// paths to files in a private temporary directory. lib/pq's sslinline mode
+// cannot be used for this since it always requires a client certificate. The
+// returned cleanup func removes the directory.
+func writeSSLMaterial(cfg dbConfig) (dbConfig, func(), error) {
+	noop := func() {}
+	if !isPEM(cfg.SSLRootCert) && !isPEM(cfg.SSLCert) && !isPEM(cfg.SSLKey) {
+		return cfg, noop, nil
+	}
+
+	dir, err := os.MkdirTemp("", "elliot-pq-ssl-")
+	if err != nil {
+		return cfg, noop, fmt.Errorf("problem creating directory for ssl material: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	for _, m := range []struct {
+		value *string
+		file  string
+	}{
+		{&cfg.SSLRootCert, "root.crt"},
+		{&cfg.SSLCert, "postgresql.crt"},
+		{&cfg.SSLKey, "postgresql.key"},
+	} {
+		if !isPEM(*m.value) {
+			continue
+		}
+		path := filepath.Join(dir, m.file)
+		// lib/pq refuses keys readable by group or others
+		if err := os.WriteFile(path, []byte(*m.value), 0600); err != nil {
+			cleanup()
+			return cfg, noop, fmt.Errorf("problem writing ssl material: %w", err)
+		}
+		*m.value = path
+	}
+
+	return cfg, cleanup, nil
+}
@@ -0,0 +1,37 @@
+package persistence
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPEM = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+func TestWriteSSLMaterial(t *testing.T) {
+	cfg, cleanup, err := writeSSLMaterial(dbConfig{SSLRootCert: testPEM, SSLCert: "/some/client.crt"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "/some/client.crt", cfg.SSLCert)
+	contents, err := os.ReadFile(cfg.SSLRootCert)
+	require.NoError(t, err)
+	assert.Equal(t, testPEM, string(contents))
+
+	info, err := os.Stat(cfg.SSLRootCert)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	cleanup()
+	_, err = os.Stat(cfg.SSLRootCert)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteSSLMaterialWithPaths(t *testing.T) {
+	in := dbConfig{SSLRootCert: "/some/root.crt"}
+	cfg, cleanup, err := writeSSLMaterial(in)
+	defer cleanup()
+	require.NoError(t, err)
+	assert.Equal(t, in, cfg)
+}
@@ -0,0 +1,26 @@
+package persistence
+
+import (
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// QuoteTable quotes a table name for use in a statement. A schema qualified
+// name such as audit.events has each part quoted separately.
+func QuoteTable(table string) string {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name)
+	}
+	return pq.QuoteIdentifier(table)
+}
+
+// IndexName returns the quoted name for an index on table made of the table
+// name and suffix. Postgres creates the index in the table's schema, so a
+// schema qualified table only contributes its name.
+func IndexName(table, suffix string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	return pq.QuoteIdentifier(table + suffix)
+}
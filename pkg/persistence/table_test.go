@@ -0,0 +1,18 @@
+package persistence
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteTable(t *testing.T) {
+	assert.Equal(t, `"users"`, QuoteTable("users"))
+	assert.Equal(t, `"audit"."events"`, QuoteTable("audit.events"))
+	assert.Equal(t, `"odd""name"`, QuoteTable(`odd"name`))
+}
+
+func TestIndexName(t *testing.T) {
+	assert.Equal(t, `"jobs_dequeue_idx"`, IndexName("jobs", "_dequeue_idx"))
+	assert.Equal(t, `"jobs_dequeue_idx"`, IndexName("work.jobs", "_dequeue_idx"))
+}
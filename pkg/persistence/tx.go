@@ -0,0 +1,94 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const defaultTxMaxAttempts = 3
+
+var txBackoff = backoff{Initial: 10 * time.Millisecond, Max: time.Second}
+
+// TxOptions configure a transaction run by WithTx
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxAttempts bounds how often the transaction is run when postgres aborts it
+	// with a serialization failure or deadlock, defaults to 3
+	MaxAttempts int
+}
+
+// WithTx runs fn in a transaction, committing if it returns nil and rolling back
+// if it returns an error or panics. fn may be called more than once, since the
+// whole transaction is retried with backoff when postgres reports a
+// serialization failure or deadlock, so it must not have side effects outside
+// the transaction. Read-only transactions run on a healthy read replica when
+// there is one, and again on the primary if the replica's connection fails.
+// opts may be nil for the defaults. Errors are classified with ClassifyError.
+func (d DB) WithTx(ctx context.Context, opts *TxOptions, fn func(*sql.Tx) error) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultTxMaxAttempts
+	}
+	txOpts := &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}
+
+	ctx, done, err := d.begin(ctx, "transaction", true)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	for attempt := 1; ; attempt++ {
+		db, replica := d.reader(opts.ReadOnly)
+		err := runTx(ctx, db, txOpts, fn)
+		if replica != nil && isFailoverError(ctx, err) {
+			d.replicas.markUnhealthy(replica, err)
+			err = runTx(ctx, d.DB, txOpts, fn)
+		}
+		if err == nil || !isRetryableTxError(err) || attempt >= maxAttempts {
+			return ClassifyError(err)
+		}
+
+		if err := sleepContext(ctx, txBackoff.duration(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+func runTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(*sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("problem beginning transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback also failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("problem committing transaction: %w", err)
+	}
+	return nil
+}
+
+// isRetryableTxError reports whether postgres aborted the transaction because
+// of a serialization failure (40001) or deadlock (40P01)
+func isRetryableTxError(err error) bool {
+	return hasCode(err, "40001", "40P01")
+}
@@ -0,0 +1,69 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhughes3/elliot/pkg/persistence/internal/sqltest"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	assert.True(t, isRetryableTxError(&pq.Error{Code: "40001"}))
+	assert.True(t, isRetryableTxError(fmt.Errorf("problem committing transaction: %w", &pq.Error{Code: "40P01"})))
+	assert.False(t, isRetryableTxError(&pq.Error{Code: "23505"}))
+	assert.False(t, isRetryableTxError(errors.New("40001")))
+}
+
+func TestWithTxCommitsAndRollsBack(t *testing.T) {
+	fake := &sqltest.Driver{}
+	db := newFakeDB(t, fake)
+
+	err := db.WithTx(context.Background(), nil, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO users VALUES (1)")
+		return err
+	})
+	require.NoError(t, err)
+
+	boom := errors.New("boom")
+	err = db.WithTx(context.Background(), nil, func(tx *sql.Tx) error { return boom })
+	assert.ErrorIs(t, err, boom)
+
+	assert.Panics(t, func() {
+		_ = db.WithTx(context.Background(), nil, func(tx *sql.Tx) error { panic("boom") })
+	})
+
+	assert.Equal(t, 3, fake.Count("BEGIN"))
+	assert.Equal(t, 1, fake.Count("COMMIT"))
+	assert.Equal(t, 2, fake.Count("ROLLBACK"))
+}
+
+func TestWithTxRetriesSerializationFailures(t *testing.T) {
+	fake := &sqltest.Driver{}
+	failures := 2
+	fake.Commit = func() error {
+		if failures > 0 {
+			failures--
+			return &pq.Error{Code: "40001"}
+		}
+		return nil
+	}
+	db := newFakeDB(t, fake)
+
+	calls := 0
+	err := db.WithTx(context.Background(), &TxOptions{Isolation: sql.LevelSerializable}, func(tx *sql.Tx) error {
+		calls++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	failures = 5
+	err = db.WithTx(context.Background(), &TxOptions{MaxAttempts: 2}, func(tx *sql.Tx) error { return nil })
+	assert.True(t, isRetryableTxError(err))
+}